@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/hirokazumiyaji/memds/memds"
@@ -266,9 +267,105 @@ func main() {
 			default:
 				fmt.Printf("%v\n", v)
 			}
+		case strings.HasPrefix(cmd, "info") || strings.HasPrefix(cmd, "INFO"):
+			tokens := strings.Split(cmd, " ")
+			m := map[string]interface{}{"cmd": "info"}
+			if len(tokens) > 1 {
+				m["section"] = tokens[1]
+			}
+			res, err := request(conn, m)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			printResponse(res, "info")
+		case strings.HasPrefix(cmd, "config") || strings.HasPrefix(cmd, "CONFIG"):
+			tokens := strings.Split(cmd, " ")
+			if len(tokens) < 2 {
+				fmt.Println("wrong number of arguments for 'config' command")
+				continue
+			}
+			m := map[string]interface{}{"cmd": "config", "sub": strings.ToLower(tokens[1])}
+			if len(tokens) > 2 {
+				m["name"] = tokens[2]
+			}
+			if len(tokens) > 3 {
+				m["value"] = strings.Join(tokens[3:], " ")
+			}
+			res, err := request(conn, m)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			printResponse(res, "config")
 		default:
 			tokens := strings.Split(cmd, " ")
 			fmt.Printf("Unknown command '%s'\n", tokens[0])
 		}
 	}
 }
+
+func request(conn net.Conn, m map[string]interface{}) (map[string]interface{}, error) {
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, &mh)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	b = append(b, '\n')
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+
+	r, _, err := bufio.NewReader(conn).ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]interface{})
+	dec := codec.NewDecoderBytes(r, &mh)
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// printResponse prints res[key] when the command succeeded, or res["msg"]
+// otherwise.
+func printResponse(res map[string]interface{}, key string) {
+	if ok, _ := res["status"].(bool); !ok {
+		printValue(res["msg"], 0)
+		return
+	}
+	printValue(res[key], 0)
+}
+
+// printValue prints nested maps as indented, sorted "key: value" lines.
+func printValue(v interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			switch t[k].(type) {
+			case map[string]interface{}:
+				if indent == 0 {
+					fmt.Printf("# %s\n", k)
+				} else {
+					fmt.Printf("%s%s:\n", pad, k)
+				}
+				printValue(t[k], indent+1)
+			default:
+				fmt.Printf("%s%s: ", pad, k)
+				printValue(t[k], 0)
+			}
+		}
+	case []uint8:
+		fmt.Printf("%s%v\n", pad, memds.Uint8ArrayToString(t))
+	default:
+		fmt.Printf("%s%v\n", pad, t)
+	}
+}
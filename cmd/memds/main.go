@@ -40,6 +40,10 @@ func main() {
 		return
 	}
 
+	if version != "" {
+		memds.Version = version
+	}
+
 	if configPath == "" {
 		config = new(memds.Config)
 		config.Port = port
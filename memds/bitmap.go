@@ -0,0 +1,596 @@
+package memds
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBitOffset keeps bitmaps below 512MB.
+const maxBitOffset = 1<<32 - 1
+
+// bitmap returns the bytes of the string value at k without decoding it, or
+// nil if k doesn't exist. The bytes are the stored value, they may only be
+// modified with b.mu held for writing. The caller must hold b.mu.
+func (b *Bucket) bitmap(k string) ([]byte, error) {
+	now := time.Now()
+	if _, ok := b.object(k, now); ok {
+		return nil, WrongTypeError
+	}
+	v, _, ok := b.lookup(k, now)
+	if !ok {
+		return nil, nil
+	}
+	p, ok := msgpackBytes(v)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return p, nil
+}
+
+// updateBitmap changes the bitmap at k with fn, grown to at least n bytes,
+// keeping the expiry of k. The stored bytes are changed in place and only
+// copied when the bitmap grows. The caller must hold b.mu for writing.
+func (b *Bucket) updateBitmap(k string, n int, fn func(p []byte)) error {
+	old, err := b.bitmap(k)
+	if err != nil {
+		return err
+	}
+	if old != nil && len(old) >= n {
+		fn(old)
+		b.changed(k)
+		return nil
+	}
+	v, p := newMsgpackStr(n)
+	copy(p, old)
+	fn(p)
+	b.putBitmap(k, v)
+	return nil
+}
+
+// putBitmap stores the msgpack str v under k, keeping the expiry of k. The
+// caller must hold b.mu for writing.
+func (b *Bucket) putBitmap(k string, v []byte) {
+	if _, _, ok := b.lookup(k, time.Now()); !ok {
+		// Drop what an expired key left behind.
+		b.remove(k)
+	}
+	b.put(k, v)
+	b.changed(k)
+}
+
+// newMsgpackStr returns an n byte msgpack str, encoded like the codec does,
+// and its bytes to fill in.
+func newMsgpackStr(n int) ([]byte, []byte) {
+	var head []byte
+	switch {
+	case n < 32:
+		head = []byte{0xa0 | byte(n)}
+	case n <= 0xffff:
+		head = []byte{0xda, byte(n >> 8), byte(n)}
+	default:
+		head = []byte{0xdb, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	v := make([]byte, len(head)+n)
+	copy(v, head)
+	return v, v[len(head):]
+}
+
+// getBit returns bit i of p, counting from the most significant bit of the
+// first byte. Bits past the end are 0.
+func getBit(p []byte, i uint64) int {
+	if i/8 >= uint64(len(p)) {
+		return 0
+	}
+	return int(p[i/8]>>(7-i%8)) & 1
+}
+
+func setBit(p []byte, i uint64, v int) {
+	mask := byte(1) << (7 - i%8)
+	if v == 1 {
+		p[i/8] |= mask
+	} else {
+		p[i/8] &^= mask
+	}
+}
+
+// countBits returns the number of set bits of p from bit i to j, j
+// excluded.
+func countBits(p []byte, i, j uint64) int {
+	n := 0
+	for ; i < j && i%8 != 0; i++ {
+		n += getBit(p, i)
+	}
+	for ; i+8 <= j; i += 8 {
+		n += bits.OnesCount8(p[i/8])
+	}
+	for ; i < j; i++ {
+		n += getBit(p, i)
+	}
+	return n
+}
+
+// findBit returns the first bit of p from bit i to j, j excluded, that is v,
+// or -1 if there is none.
+func findBit(p []byte, i, j uint64, v int) int64 {
+	skip := byte(0)
+	if v == 0 {
+		skip = 0xff
+	}
+	for i < j {
+		if i%8 == 0 && i+8 <= j && p[i/8] == skip {
+			i += 8
+			continue
+		}
+		if getBit(p, i) == v {
+			return int64(i)
+		}
+		i++
+	}
+	return -1
+}
+
+// bitRange converts the "start" and "end" of cmd, which count from the end
+// if negative, into a range of bits over p. They count bytes unless "unit"
+// is bit. set reports whether "end" was given.
+func (s *Store) bitRange(cmd map[string]interface{}, p []byte) (i, j uint64, set bool, errRes map[string]interface{}) {
+	start, errRes := s.optionalIntArg(cmd, "start", 0)
+	if errRes != nil {
+		return
+	}
+	end, errRes := s.optionalIntArg(cmd, "end", -1)
+	if errRes != nil {
+		return
+	}
+	unit, errRes := s.optionalStringArg(cmd, "unit")
+	if errRes != nil {
+		return
+	}
+	_, set = cmd["end"]
+
+	switch unit {
+	case "", "byte":
+		lo, hi := rangeIndex(start, end, len(p))
+		return uint64(lo) * 8, uint64(hi) * 8, set, nil
+	case "bit":
+		lo, hi := rangeIndex(start, end, len(p)*8)
+		return uint64(lo), uint64(hi), set, nil
+	default:
+		return 0, 0, false, s.responseCmdFormatError("key 'unit' not byte or bit")
+	}
+}
+
+// bitOffsetArg returns cmd[name] as a bit offset.
+func (s *Store) bitOffsetArg(cmd map[string]interface{}, name string) (uint64, map[string]interface{}) {
+	if _, ok := cmd[name]; !ok {
+		return 0, s.responseCmdFormatError(fmt.Sprintf("key '%s' not found", name))
+	}
+	n, errRes := s.optionalIntArg(cmd, name, 0)
+	if errRes != nil {
+		return 0, errRes
+	}
+	if n < 0 || n > maxBitOffset {
+		return 0, s.responseCmdFormatError(fmt.Sprintf("key '%s' out of range", name))
+	}
+	return uint64(n), nil
+}
+
+// bitArg returns cmd["bit"], which must be 0 or 1.
+func (s *Store) bitArg(cmd map[string]interface{}) (int, map[string]interface{}) {
+	n, errRes := s.optionalIntArg(cmd, "bit", -1)
+	if errRes != nil {
+		return 0, errRes
+	}
+	if n != 0 && n != 1 {
+		return 0, s.responseCmdFormatError("key 'bit' not 0 or 1")
+	}
+	return int(n), nil
+}
+
+func (s *Store) execBitmap(name string, cmd map[string]interface{}) map[string]interface{} {
+	if name == "bitop" {
+		return s.execBitop(cmd)
+	}
+
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+
+	var res map[string]interface{}
+	switch name {
+	case "setbit":
+		off, errRes := s.bitOffsetArg(cmd, "offset")
+		if errRes != nil {
+			return errRes
+		}
+		bit, errRes := s.bitArg(cmd)
+		if errRes != nil {
+			return errRes
+		}
+		err := s.update(k, func(b *Bucket) error {
+			return b.updateBitmap(k, int(off/8)+1, func(p []byte) {
+				res = s.response(map[string]interface{}{"value": getBit(p, off)})
+				setBit(p, off, bit)
+			})
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+	case "getbit":
+		off, errRes := s.bitOffsetArg(cmd, "offset")
+		if errRes != nil {
+			return errRes
+		}
+		err := s.view(k, func(b *Bucket) error {
+			p, err := b.bitmap(k)
+			res = s.response(map[string]interface{}{"value": getBit(p, off)})
+			return err
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+	case "bitcount", "bitpos":
+		var bit int
+		if name == "bitpos" {
+			if bit, errRes = s.bitArg(cmd); errRes != nil {
+				return errRes
+			}
+		}
+		err := s.view(k, func(b *Bucket) error {
+			p, err := b.bitmap(k)
+			if err != nil {
+				return err
+			}
+			i, j, end, errRes := s.bitRange(cmd, p)
+			if errRes != nil {
+				res = errRes
+				return nil
+			}
+			if name == "bitcount" {
+				res = s.response(map[string]interface{}{"value": countBits(p, i, j)})
+				return nil
+			}
+			pos := findBit(p, i, j, bit)
+			if pos < 0 && bit == 0 && !end {
+				// Without an end, the bits past the value are clear.
+				pos = int64(len(p)) * 8
+			}
+			res = s.response(map[string]interface{}{"value": pos})
+			return nil
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+	case "bitfield":
+		return s.execBitfield(k, cmd)
+	default:
+		return s.responseCmdNotFoundError()
+	}
+	return res
+}
+
+// execBitop stores the bitwise and, or or xor of "keys", or not of the one
+// key, in "dest". Shorter values are padded with zero bytes.
+func (s *Store) execBitop(cmd map[string]interface{}) map[string]interface{} {
+	op, errRes := s.stringArg(cmd, "op")
+	if errRes != nil {
+		return errRes
+	}
+	dest, errRes := s.stringArg(cmd, "dest")
+	if errRes != nil {
+		return errRes
+	}
+	keys, errRes := s.stringsArg(cmd, "keys")
+	if errRes != nil {
+		return errRes
+	}
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(keys) != 1 {
+			return s.responseCmdFormatError("bitop not takes one key")
+		}
+	default:
+		return s.responseCmdFormatError("key 'op' not and, or, xor or not")
+	}
+
+	srcs := make([][]byte, 0, len(keys))
+	n := 0
+	for _, k := range keys {
+		err := s.view(k, func(b *Bucket) error {
+			p, err := b.bitmap(k)
+			srcs = append(srcs, p)
+			return err
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		if len(srcs[len(srcs)-1]) > n {
+			n = len(srcs[len(srcs)-1])
+		}
+	}
+
+	v, p := newMsgpackStr(n)
+	for i := range p {
+		r := byteAt(srcs[0], i)
+		for _, src := range srcs[1:] {
+			switch op {
+			case "and":
+				r &= byteAt(src, i)
+			case "or":
+				r |= byteAt(src, i)
+			case "xor":
+				r ^= byteAt(src, i)
+			}
+		}
+		if op == "not" {
+			r = ^r
+		}
+		p[i] = r
+	}
+
+	err := s.update(dest, func(b *Bucket) error {
+		b.remove(dest)
+		if n > 0 {
+			b.put(dest, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.response(map[string]interface{}{"value": n})
+}
+
+func byteAt(p []byte, i int) byte {
+	if i < len(p) {
+		return p[i]
+	}
+	return 0
+}
+
+// bitfieldType is the integer type of a bitfield, like i5 or u8.
+type bitfieldType struct {
+	signed bool
+	width  uint
+}
+
+func parseBitfieldType(s string) (bitfieldType, bool) {
+	var t bitfieldType
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return t, false
+	}
+	n, err := strconv.ParseUint(s[1:], 10, 8)
+	if err != nil {
+		return t, false
+	}
+	t.signed, t.width = s[0] == 'i', uint(n)
+	if t.width < 1 || t.width > 64 || (!t.signed && t.width == 64) {
+		return t, false
+	}
+	return t, true
+}
+
+func (t bitfieldType) min() int64 {
+	if !t.signed {
+		return 0
+	}
+	return -t.max() - 1
+}
+
+func (t bitfieldType) max() int64 {
+	if t.signed {
+		return int64(uint64(1)<<(t.width-1) - 1)
+	}
+	return int64(uint64(1)<<t.width - 1)
+}
+
+// value returns the width bits of u as an integer of t.
+func (t bitfieldType) value(u uint64) int64 {
+	if t.width < 64 {
+		u &= uint64(1)<<t.width - 1
+		if t.signed && u>>(t.width-1) == 1 {
+			u |= ^uint64(0) << t.width
+		}
+	}
+	return int64(u)
+}
+
+// fit returns v as an integer of t. Values out of range wrap around, or
+// saturate if overflow is sat. It returns false if v is out of range and
+// overflow is fail.
+func (t bitfieldType) fit(v *big.Int, overflow string) (int64, bool) {
+	min, max := big.NewInt(t.min()), big.NewInt(t.max())
+	if v.Cmp(min) >= 0 && v.Cmp(max) <= 0 {
+		return v.Int64(), true
+	}
+	switch overflow {
+	case "sat":
+		if v.Sign() < 0 {
+			return t.min(), true
+		}
+		return t.max(), true
+	case "fail":
+		return 0, false
+	default:
+		mask := new(big.Int).Lsh(big.NewInt(1), t.width)
+		mask.Sub(mask, big.NewInt(1))
+		return t.value(new(big.Int).And(v, mask).Uint64()), true
+	}
+}
+
+func getField(p []byte, off uint64, width uint) uint64 {
+	var v uint64
+	for i := uint64(0); i < uint64(width); i++ {
+		v = v<<1 | uint64(getBit(p, off+i))
+	}
+	return v
+}
+
+func setField(p []byte, off uint64, width uint, v uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		setBit(p, off+i, int(v>>(uint64(width)-1-i))&1)
+	}
+}
+
+// bitfieldOp is one get, set or incrby of a bitfield command.
+type bitfieldOp struct {
+	op       string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow string
+}
+
+// bitfieldOpsArg parses cmd["ops"], a list of maps with "op", "type",
+// "offset" and "value" for set or "increment" for incrby. An "overflow" op
+// sets how the ops after it overflow: wrap, sat or fail.
+func (s *Store) bitfieldOpsArg(cmd map[string]interface{}) ([]bitfieldOp, bool, map[string]interface{}) {
+	list, ok := cmd["ops"].([]interface{})
+	if !ok {
+		return nil, false, s.responseCmdFormatError("key 'ops' not type list")
+	}
+	var (
+		ops      []bitfieldOp
+		write    bool
+		overflow = "wrap"
+	)
+	for _, e := range list {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, false, s.responseCmdFormatError("key 'ops' not type map list")
+		}
+		name, errRes := s.stringArg(m, "op")
+		if errRes != nil {
+			return nil, false, errRes
+		}
+		if name == "overflow" {
+			if overflow, errRes = s.stringArg(m, "overflow"); errRes != nil {
+				return nil, false, errRes
+			}
+			if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+				return nil, false, s.responseCmdFormatError("key 'overflow' not wrap, sat or fail")
+			}
+			continue
+		}
+
+		op := bitfieldOp{op: name, overflow: overflow}
+		typ, errRes := s.stringArg(m, "type")
+		if errRes != nil {
+			return nil, false, errRes
+		}
+		if op.typ, ok = parseBitfieldType(typ); !ok {
+			return nil, false, s.responseCmdFormatError("key 'type' not a bitfield type")
+		}
+		if op.offset, errRes = s.bitfieldOffsetArg(m, op.typ); errRes != nil {
+			return nil, false, errRes
+		}
+		switch name {
+		case "get":
+		case "set", "incrby":
+			arg := "value"
+			if name == "incrby" {
+				arg = "increment"
+			}
+			if _, ok := m[arg]; !ok {
+				return nil, false, s.responseCmdFormatError(fmt.Sprintf("key '%s' not found", arg))
+			}
+			if op.value, errRes = s.optionalIntArg(m, arg, 0); errRes != nil {
+				return nil, false, errRes
+			}
+			write = true
+		default:
+			return nil, false, s.responseCmdFormatError("key 'op' not get, set, incrby or overflow")
+		}
+		ops = append(ops, op)
+	}
+	return ops, write, nil
+}
+
+// bitfieldOffsetArg returns the "offset" of m in bits. An offset like "#2"
+// counts in fields of type t.
+func (s *Store) bitfieldOffsetArg(m map[string]interface{}, t bitfieldType) (uint64, map[string]interface{}) {
+	switch m["offset"].(type) {
+	case string, []uint8:
+	default:
+		off, errRes := s.bitOffsetArg(m, "offset")
+		if errRes != nil {
+			return 0, errRes
+		}
+		if off+uint64(t.width)-1 > maxBitOffset {
+			return 0, s.responseCmdFormatError("key 'offset' out of range")
+		}
+		return off, nil
+	}
+	str, _ := s.optionalStringArg(m, "offset")
+	if !strings.HasPrefix(str, "#") {
+		return 0, s.responseCmdFormatError("key 'offset' not type int")
+	}
+	n, err := strconv.ParseUint(str[1:], 10, 64)
+	if err != nil || n > maxBitOffset/uint64(t.width) || (n+1)*uint64(t.width)-1 > maxBitOffset {
+		return 0, s.responseCmdFormatError("key 'offset' out of range")
+	}
+	return n * uint64(t.width), nil
+}
+
+// execBitfield runs the ops of a bitfield command in order and returns
+// their results, nil for ops that failed to overflow.
+func (s *Store) execBitfield(k string, cmd map[string]interface{}) map[string]interface{} {
+	ops, write, errRes := s.bitfieldOpsArg(cmd)
+	if errRes != nil {
+		return errRes
+	}
+
+	r := make([]interface{}, 0, len(ops))
+	run := func(p []byte) {
+		for _, op := range ops {
+			old := op.typ.value(getField(p, op.offset, op.typ.width))
+			if op.op == "get" {
+				r = append(r, old)
+				continue
+			}
+			v := big.NewInt(op.value)
+			if op.op == "incrby" {
+				v.Add(v, big.NewInt(old))
+			}
+			n, ok := op.typ.fit(v, op.overflow)
+			if !ok {
+				r = append(r, nil)
+				continue
+			}
+			setField(p, op.offset, op.typ.width, uint64(n))
+			if op.op == "set" {
+				r = append(r, old)
+			} else {
+				r = append(r, n)
+			}
+		}
+	}
+
+	var err error
+	if write {
+		size := 0
+		for _, op := range ops {
+			if n := int((op.offset+uint64(op.typ.width)-1)/8) + 1; op.op != "get" && n > size {
+				size = n
+			}
+		}
+		err = s.update(k, func(b *Bucket) error {
+			return b.updateBitmap(k, size, run)
+		})
+	} else {
+		err = s.view(k, func(b *Bucket) error {
+			p, err := b.bitmap(k)
+			run(p)
+			return err
+		})
+	}
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.response(map[string]interface{}{"value": r})
+}
@@ -0,0 +1,254 @@
+package memds
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestBitmap(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	for _, off := range []int{1, 7, 8, 100} {
+		exec(map[string]interface{}{"cmd": "setbit", "key": "dau", "offset": off, "bit": 1})
+	}
+	s.Set("str", []byte("foobar"))
+
+	testCase := []struct {
+		Cmd   map[string]interface{}
+		Value interface{}
+	}{
+		{
+			Cmd:   map[string]interface{}{"cmd": "setbit", "key": "dau", "offset": 7, "bit": 0},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "getbit", "key": "dau", "offset": 7},
+			Value: 0,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "getbit", "key": "dau", "offset": 1000},
+			Value: 0,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitcount", "key": "dau"},
+			Value: 3,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitcount", "key": "str", "start": 1, "end": 1},
+			Value: 6,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitcount", "key": "str", "start": 5, "end": 30, "unit": "bit"},
+			Value: 17,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitcount", "key": "str", "start": -2},
+			Value: 7,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitpos", "key": "dau", "bit": 1, "start": 1},
+			Value: int64(8),
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitpos", "key": "dau", "bit": 1, "start": 2, "end": 12},
+			Value: int64(100),
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitpos", "key": "dau", "bit": 1, "start": 9, "end": 20, "unit": "bit"},
+			Value: int64(-1),
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bitpos", "key": "missing", "bit": 0},
+			Value: int64(0),
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "get", "key": "dau"},
+			Value: []byte{0x40, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x08},
+		},
+	}
+	for _, tc := range testCase {
+		if res := exec(tc.Cmd); !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res, tc.Value)
+		}
+	}
+
+	exec(map[string]interface{}{"cmd": "setbit", "key": "big", "offset": 1000, "bit": 1})
+	if v, err := s.Get("big"); err != nil || len(v.([]byte)) != 126 {
+		t.Errorf("got: %v %v, want: 126 bytes", v, err)
+	}
+
+	exec(map[string]interface{}{"cmd": "xadd", "key": "stream", "fields": map[string]interface{}{"a": 1}})
+	s.Set("int", 1)
+	for _, k := range []string{"stream", "int"} {
+		res := exec(map[string]interface{}{"cmd": "getbit", "key": k, "offset": 0})
+		if res["msg"] != WrongTypeError.Error() {
+			t.Errorf("got: %v, want: %v", res, WrongTypeError)
+		}
+	}
+	res := exec(map[string]interface{}{"cmd": "setbit", "key": "dau", "offset": int64(maxBitOffset) + 1, "bit": 1})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+}
+
+func TestBitmapInPlace(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2, RawValues: true})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	exec(map[string]interface{}{"cmd": "setbit", "key": "b", "offset": 1000, "bit": 1})
+	v := s.bucket("b").value["b"]
+	got := exec(map[string]interface{}{"cmd": "get", "key": "b"})["value"].(rawValue)
+
+	exec(map[string]interface{}{"cmd": "setbit", "key": "b", "offset": 0, "bit": 1})
+	if w := s.bucket("b").value["b"]; &w[0] != &v[0] {
+		t.Error("got: a copy, want: the stored bytes changed in place")
+	}
+	// 126 bytes after a str16 header.
+	if len(v) != 129 || v[3] != 0x80 {
+		t.Errorf("got: %x, want: 80 after the header", v)
+	}
+	if got[3] != 0 {
+		t.Errorf("got: %x, want: a get unchanged by later writes", got[3])
+	}
+
+	exec(map[string]interface{}{"cmd": "setbit", "key": "b", "offset": 2000, "bit": 1})
+	if res := exec(map[string]interface{}{"cmd": "bitcount", "key": "b"}); res["value"] != 3 {
+		t.Errorf("got: %v, want: 3", res)
+	}
+}
+
+func BenchmarkSetbit(b *testing.B) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	exec(map[string]interface{}{"cmd": "setbit", "key": "b", "offset": 1 << 23, "bit": 1})
+	cmd := map[string]interface{}{"cmd": "setbit", "key": "b", "offset": 0, "bit": 1}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmd["offset"] = i % (1 << 23)
+		exec(cmd)
+	}
+}
+
+func TestBitop(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	s.Set("a", []byte{0xf0, 0x0f})
+	s.Set("b", []byte{0x3c})
+
+	testCase := []struct {
+		Op    string
+		Keys  []interface{}
+		Value interface{}
+	}{
+		{Op: "and", Keys: []interface{}{"a", "b"}, Value: []byte{0x30, 0x00}},
+		{Op: "or", Keys: []interface{}{"a", "b"}, Value: []byte{0xfc, 0x0f}},
+		{Op: "xor", Keys: []interface{}{"a", "b", "missing"}, Value: []byte{0xcc, 0x0f}},
+		{Op: "not", Keys: []interface{}{"b"}, Value: []byte{0xc3}},
+		{Op: "or", Keys: []interface{}{"missing"}, Value: nil},
+	}
+	for _, tc := range testCase {
+		exec(map[string]interface{}{"cmd": "bitop", "op": tc.Op, "dest": "dest", "keys": tc.Keys})
+		if v, _ := s.Get("dest"); !reflect.DeepEqual(v, tc.Value) {
+			t.Errorf("%s %v got: %v, want: %v", tc.Op, tc.Keys, v, tc.Value)
+		}
+	}
+
+	res := exec(map[string]interface{}{"cmd": "bitop", "op": "not", "dest": "dest", "keys": []interface{}{"a", "b"}})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(ops ...map[string]interface{}) interface{} {
+		ls := make([]interface{}, 0, len(ops))
+		for _, op := range ops {
+			ls = append(ls, op)
+		}
+		res := s.execute(map[string]interface{}{"cmd": "bitfield", "key": "f", "ops": ls}, new(client))
+		if res["status"] != true {
+			t.Fatalf("got: %v", res)
+		}
+		return res["value"]
+	}
+	op := func(name, typ string, offset interface{}, v int64) map[string]interface{} {
+		m := map[string]interface{}{"op": name, "type": typ, "offset": offset}
+		switch name {
+		case "set":
+			m["value"] = v
+		case "incrby":
+			m["increment"] = v
+		}
+		return m
+	}
+	overflow := func(o string) map[string]interface{} {
+		return map[string]interface{}{"op": "overflow", "overflow": o}
+	}
+
+	testCase := []struct {
+		Ops   []map[string]interface{}
+		Value []interface{}
+	}{
+		{
+			Ops:   []map[string]interface{}{op("set", "u8", "#1", 200), op("get", "u8", 8, 0), op("get", "i8", 8, 0)},
+			Value: []interface{}{int64(0), int64(200), int64(-56)},
+		},
+		{
+			Ops:   []map[string]interface{}{op("incrby", "u8", 8, 100), op("incrby", "i5", 100, -20)},
+			Value: []interface{}{int64(44), int64(12)},
+		},
+		{
+			Ops: []map[string]interface{}{
+				overflow("sat"), op("incrby", "u8", 8, 300), op("incrby", "i64", 128, -1<<63),
+				overflow("fail"), op("incrby", "u8", 8, 1), op("get", "u8", 8, 0),
+			},
+			Value: []interface{}{int64(255), int64(-1 << 63), nil, int64(255)},
+		},
+		{
+			Ops:   []map[string]interface{}{op("set", "i64", 128, 1<<62), op("incrby", "i64", 128, 1<<62)},
+			Value: []interface{}{int64(-1 << 63), int64(-1 << 63)},
+		},
+	}
+	for _, tc := range testCase {
+		if v := exec(tc.Ops...); !reflect.DeepEqual(v, tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Ops, v, tc.Value)
+		}
+	}
+
+	res := s.execute(map[string]interface{}{"cmd": "bitfield", "key": "f", "ops": []interface{}{op("get", "u64", 0, 0)}}, new(client))
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+}
+
+func TestBitmapCAS(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	execMemcachedText(s, "set key 0 0 1\r\nA\r\n")
+
+	// Changing the bytes in place and growing them both give a new cas.
+	for _, off := range []int{6, 100} {
+		it, ok := s.mcGet(new(client), "key")
+		if !ok {
+			t.Fatal("key not found")
+		}
+		res := s.execute(map[string]interface{}{"cmd": "setbit", "key": "key", "offset": off, "bit": 1}, new(client))
+		if res["status"] != true {
+			t.Fatalf("got: %v", res)
+		}
+		out := execMemcachedText(s, "cas key 0 0 1 "+strconv.FormatUint(it.cas, 10)+"\r\nx\r\n")
+		if out != "EXISTS\r\n" {
+			t.Errorf("offset: %v, got: %q, want: %q", off, out, "EXISTS\r\n")
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package memds
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// waiters wakes commands blocked on keys once the keys change. Waiters of a
+// key are queued in arrival order so pops can serve them fairly.
+type waiters struct {
+	mu   sync.Mutex
+	keys map[string][]chan struct{}
+	stop chan struct{}
+}
+
+// add queues a waiter on keys and returns the channel signalled when one of
+// them changes.
+func (ws *waiters) add(keys []string) chan struct{} {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.keys == nil {
+		ws.keys = make(map[string][]chan struct{})
+	}
+	ch := make(chan struct{}, 1)
+	for _, k := range keys {
+		ws.keys[k] = append(ws.keys[k], ch)
+	}
+	return ch
+}
+
+// remove dequeues the waiter ch. The others are woken, one of them may be
+// first in line now.
+func (ws *waiters) remove(keys []string, ch chan struct{}) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, k := range keys {
+		q := ws.keys[k]
+		for i, c := range q {
+			if c == ch {
+				q = append(q[:i:i], q[i+1:]...)
+				break
+			}
+		}
+		if len(q) == 0 {
+			delete(ws.keys, k)
+			continue
+		}
+		ws.keys[k] = q
+		ws.signal(q)
+	}
+}
+
+// turn reports whether the waiter ch is first in line for k. A nil ch is
+// a command not waiting yet, its turn is only if nobody waits on k.
+func (ws *waiters) turn(k string, ch chan struct{}) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	q := ws.keys[k]
+	if ch == nil {
+		return len(q) == 0
+	}
+	return len(q) > 0 && q[0] == ch
+}
+
+func (ws *waiters) notify(k string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.signal(ws.keys[k])
+}
+
+// signal wakes the waiters of q. The caller must hold ws.mu.
+func (ws *waiters) signal(q []chan struct{}) {
+	for _, ch := range q {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stopped returns a channel closed once the store stops serving blocked
+// commands.
+func (ws *waiters) stopped() chan struct{} {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.stop == nil {
+		ws.stop = make(chan struct{})
+	}
+	return ws.stop
+}
+
+// StopBlocking makes blocked commands return as if they timed out, and later
+// ones return at once. Server.Shutdown and Close call it.
+func (s *Store) StopBlocking() {
+	ch := s.waiters.stopped()
+	s.waiters.mu.Lock()
+	defer s.waiters.mu.Unlock()
+
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// block calls try until it reports true, waiting for one of keys to change
+// between calls. try gets the waiter channel, to check with waiters.turn
+// whether it is its turn. block gives up and returns false after timeout, 0
+// waits without limit, or once c disconnects or the store stops.
+func (s *Store) block(keys []string, timeout time.Duration, c *client, try func(ch chan struct{}) bool) bool {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	stop := s.waiters.stopped()
+	gone, unwatch := c.watch()
+	defer unwatch()
+
+	// Queue before trying, so a change in between isn't missed.
+	ch := s.waiters.add(keys)
+	defer s.waiters.remove(keys, ch)
+
+	for {
+		if try(ch) {
+			return true
+		}
+		if !c.wait(ch, deadline, stop, gone) {
+			return false
+		}
+	}
+}
+
+// wait waits for ch and reports whether it came before deadline, stop and
+// gone. Scripts can run meanwhile.
+func (c *client) wait(ch chan struct{}, deadline <-chan time.Time, stop chan struct{}, gone <-chan struct{}) bool {
+	if c != nil && c.gate != nil {
+		c.gate.Unlock()
+		defer c.gate.Lock()
+	}
+	select {
+	case <-ch:
+		return true
+	case <-deadline:
+		return false
+	case <-stop:
+		return false
+	case <-gone:
+		return false
+	}
+}
+
+// watch returns a channel closed if the client disconnects before unwatch is
+// called. It reads ahead on the connection, so it must only run while no
+// command is being read. Clients without a connection are gone once done is
+// closed.
+func (c *client) watch() (<-chan struct{}, func()) {
+	if c == nil {
+		return nil, func() {}
+	}
+	if c.conn == nil || c.reader == nil {
+		return c.done, func() {}
+	}
+	gone := make(chan struct{})
+	exited := make(chan struct{})
+
+	// Blocked commands have no idle time.
+	c.conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(exited)
+		_, err := c.reader.Peek(1)
+		if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+			return
+		}
+		close(gone)
+	}()
+
+	return gone, func() {
+		// Wake the read up and wait for it, the connection is read
+		// by accept again once the command returns.
+		c.conn.SetReadDeadline(time.Now())
+		<-exited
+	}
+}
@@ -0,0 +1,311 @@
+package memds
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultBloomErrorRate = 0.01
+	defaultBloomCapacity  = 100
+	defaultFilterExpand   = 2
+	// bloomTightening is the ratio of the error rates of one filter and the
+	// next of a scaling bloom filter, which keeps the overall error rate
+	// below the one reserved.
+	bloomTightening = 0.5
+	// maxFilterCapacity, maxFilterExpansion and maxBloomBits bound what a
+	// reserve command can allocate. A scaling bloom filter stops growing
+	// once its filters would hold more than maxBloomBits.
+	maxFilterCapacity  = 1 << 26
+	maxFilterExpansion = 32
+	maxBloomBits       = 1 << 30
+)
+
+// bloomBits returns the number of bits of a bloom filter for capacity items
+// at errorRate.
+func bloomBits(capacity int, errorRate float64) float64 {
+	return math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+}
+
+// bloomFilter is one fixed size bloom filter.
+type bloomFilter struct {
+	bits     []uint64
+	m        uint64
+	k        uint64
+	capacity int
+	n        int
+}
+
+func newBloomFilter(capacity int, errorRate float64) *bloomFilter {
+	m := uint64(bloomBits(capacity, errorRate))
+	if m < 64 {
+		m = 64
+	}
+	f := bloomFilter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        uint64(math.Ceil(-math.Log2(errorRate))),
+		capacity: capacity,
+	}
+	return &f
+}
+
+// hashes returns the two hashes the bits of e are derived from.
+func filterHashes(e []byte) (uint64, uint64) {
+	return murmur64a(e, 0xadc83b19), murmur64a(e, 0x5bd1e995)
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.n++
+}
+
+// bloom is a scaling bloom filter. Once the last filter holds its capacity
+// a filter expansion times larger is added.
+type bloom struct {
+	filters    []*bloomFilter
+	errorRate  float64
+	expansion  int
+	nonScaling bool
+}
+
+func newBloom(capacity int, errorRate float64, expansion int, nonScaling bool) *bloom {
+	b := bloom{
+		errorRate:  errorRate,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+	}
+	b.filters = []*bloomFilter{newBloomFilter(capacity, errorRate*bloomTightening)}
+	return &b
+}
+
+func newDefaultBloom() interface{} {
+	return newBloom(defaultBloomCapacity, defaultBloomErrorRate, defaultFilterExpand, false)
+}
+
+func (b *bloom) exists(e []byte) bool {
+	h1, h2 := filterHashes(e)
+	for _, f := range b.filters {
+		if f.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds e and reports whether it is new, that is not reported as
+// present before.
+func (b *bloom) add(e []byte) (bool, error) {
+	h1, h2 := filterHashes(e)
+	for _, f := range b.filters {
+		if f.has(h1, h2) {
+			return false, nil
+		}
+	}
+	f := b.filters[len(b.filters)-1]
+	if f.n >= f.capacity {
+		if b.nonScaling {
+			return false, FilterFullError
+		}
+		rate := b.errorRate * math.Pow(bloomTightening, float64(len(b.filters)+1))
+		capacity := f.capacity * b.expansion
+		if f.capacity > maxFilterCapacity/b.expansion {
+			capacity = maxFilterCapacity
+		}
+		// The filters of a key together hold at most maxBloomBits.
+		if float64(b.size()*8)+bloomBits(capacity, rate) > maxBloomBits {
+			return false, FilterFullError
+		}
+		f = newBloomFilter(capacity, rate)
+		b.filters = append(b.filters, f)
+	}
+	f.add(h1, h2)
+	return true, nil
+}
+
+func (b *bloom) size() int64 {
+	var n int64
+	for _, f := range b.filters {
+		n += int64(len(f.bits) * 8)
+	}
+	return n
+}
+
+func (b *bloom) info() map[string]interface{} {
+	capacity, n, size := 0, 0, 0
+	for _, f := range b.filters {
+		capacity += f.capacity
+		n += f.n
+		size += len(f.bits) * 8
+	}
+	return map[string]interface{}{
+		"capacity":  capacity,
+		"size":      size,
+		"filters":   len(b.filters),
+		"items":     n,
+		"expansion": b.expansion,
+	}
+}
+
+// bloom returns the bloom filter at k, creating one with the default error
+// rate and capacity if create is set. It returns nil if k doesn't exist.
+// The caller must hold b.mu, for writing if create is set.
+func (b *Bucket) bloom(k string, create bool) (*bloom, error) {
+	var f func() interface{}
+	if create {
+		f = newDefaultBloom
+	}
+	o, err := b.typed(k, f)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	bf, ok := o.(*bloom)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return bf, nil
+}
+
+// filterOptionsArg returns the "capacity" and "expansion" of cmd.
+func (s *Store) filterOptionsArg(cmd map[string]interface{}, capacity int64) (int, int, map[string]interface{}) {
+	capacity, errRes := s.optionalIntArg(cmd, "capacity", capacity)
+	if errRes != nil {
+		return 0, 0, errRes
+	}
+	if capacity < 1 || capacity > maxFilterCapacity {
+		return 0, 0, s.responseCmdFormatError(fmt.Sprintf("key 'capacity' not between 1 and %d", maxFilterCapacity))
+	}
+	expansion, errRes := s.optionalIntArg(cmd, "expansion", defaultFilterExpand)
+	if errRes != nil {
+		return 0, 0, errRes
+	}
+	if expansion < 1 || expansion > maxFilterExpansion {
+		return 0, 0, s.responseCmdFormatError(fmt.Sprintf("key 'expansion' not between 1 and %d", maxFilterExpansion))
+	}
+	return int(capacity), int(expansion), nil
+}
+
+func (s *Store) execBloom(name string, cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+
+	var items []string
+	switch name {
+	case "bf.add", "bf.exists":
+		item, errRes := s.stringArg(cmd, "item")
+		if errRes != nil {
+			return errRes
+		}
+		items = []string{item}
+	case "bf.madd", "bf.mexists":
+		if items, errRes = s.stringsArg(cmd, "items"); errRes != nil {
+			return errRes
+		}
+	}
+
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		if name == "bf.reserve" {
+			res = s.execBloomReserve(b, k, cmd)
+			return nil
+		}
+
+		bf, err := b.bloom(k, name == "bf.add" || name == "bf.madd")
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "bf.add", "bf.madd":
+			r := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				added, err := bf.add([]byte(item))
+				if err != nil {
+					if len(items) == 1 {
+						return err
+					}
+					r = append(r, err.Error())
+					continue
+				}
+				r = append(r, boolInt(added))
+			}
+			if name == "bf.add" {
+				res = s.response(map[string]interface{}{"value": r[0]})
+			} else {
+				res = s.response(map[string]interface{}{"value": r})
+			}
+		case "bf.exists", "bf.mexists":
+			r := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				r = append(r, boolInt(bf != nil && bf.exists([]byte(item))))
+			}
+			if name == "bf.exists" {
+				res = s.response(map[string]interface{}{"value": r[0]})
+			} else {
+				res = s.response(map[string]interface{}{"value": r})
+			}
+		case "bf.info":
+			if bf == nil {
+				return ValueNotFoundError
+			}
+			res = s.response(map[string]interface{}{"value": bf.info()})
+		default:
+			res = s.responseCmdNotFoundError()
+		}
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return res
+}
+
+func (s *Store) execBloomReserve(b *Bucket, k string, cmd map[string]interface{}) map[string]interface{} {
+	rate := defaultBloomErrorRate
+	if v, ok := cmd["error_rate"]; ok {
+		f, ok := floatValue(v)
+		if !ok || !(f > 0 && f < 1) {
+			return s.responseCmdFormatError("key 'error_rate' not a number between 0 and 1")
+		}
+		rate = f
+	}
+	capacity, expansion, errRes := s.filterOptionsArg(cmd, defaultBloomCapacity)
+	if errRes != nil {
+		return errRes
+	}
+	if bloomBits(capacity, rate) > maxBloomBits {
+		return s.responseCmdFormatError("key 'capacity' too large for 'error_rate'")
+	}
+
+	o, err := b.typed(k, nil)
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if o != nil {
+		return s.responseCmdExecuteError(FilterExistsError.Error())
+	}
+	nonScaling, _ := cmd["nonscaling"].(bool)
+	b.setObject(k, newBloom(capacity, rate, expansion, nonScaling))
+	return s.responseOK()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package memds
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	res := exec(map[string]interface{}{"cmd": "bf.reserve", "key": "ids", "error_rate": 0.01, "capacity": 1000})
+	if res["status"] != true {
+		t.Fatalf("got: %v", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "bf.reserve", "key": "ids"})
+	if res["msg"] != FilterExistsError.Error() {
+		t.Errorf("got: %v, want: %v", res, FilterExistsError)
+	}
+
+	// Adding 10 times the capacity scales the filter.
+	n := 10000
+	items := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		items = append(items, fmt.Sprintf("id:%d", i))
+	}
+	exec(map[string]interface{}{"cmd": "bf.madd", "key": "ids", "items": items})
+	res = exec(map[string]interface{}{"cmd": "bf.mexists", "key": "ids", "items": items})
+	for i, v := range res["value"].([]interface{}) {
+		if v != 1 {
+			t.Fatalf("%v got: %v, want: 1", items[i], v)
+		}
+	}
+	fp := 0
+	for i := 0; i < n; i++ {
+		res := exec(map[string]interface{}{"cmd": "bf.exists", "key": "ids", "item": fmt.Sprintf("other:%d", i)})
+		fp += res["value"].(int)
+	}
+	if rate := float64(fp) / float64(n); rate > 0.01 {
+		t.Errorf("got: false positive rate %v, want: at most 0.01", rate)
+	}
+	info := exec(map[string]interface{}{"cmd": "bf.info", "key": "ids"})["value"].(map[string]interface{})
+	// False positives are not added.
+	if items := info["items"].(int); items > n || items < n*98/100 || info["filters"].(int) < 4 {
+		t.Errorf("got: %v", info)
+	}
+
+	testCase := []struct {
+		Cmd   map[string]interface{}
+		Value interface{}
+	}{
+		{
+			Cmd:   map[string]interface{}{"cmd": "bf.add", "key": "auto", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bf.madd", "key": "auto", "items": []interface{}{"a", "b"}},
+			Value: []interface{}{0, 1},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "bf.exists", "key": "missing", "item": "a"},
+			Value: 0,
+		},
+	}
+	for _, tc := range testCase {
+		if res := exec(tc.Cmd); !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res, tc.Value)
+		}
+	}
+
+	exec(map[string]interface{}{"cmd": "bf.reserve", "key": "fixed", "capacity": 1, "nonscaling": true})
+	exec(map[string]interface{}{"cmd": "bf.add", "key": "fixed", "item": "a"})
+	res = exec(map[string]interface{}{"cmd": "bf.add", "key": "fixed", "item": "b"})
+	if res["msg"] != FilterFullError.Error() {
+		t.Errorf("got: %v, want: %v", res, FilterFullError)
+	}
+
+	s.Set("plain", "value")
+	res = exec(map[string]interface{}{"cmd": "bf.add", "key": "plain", "item": "a"})
+	if res["msg"] != WrongTypeError.Error() {
+		t.Errorf("got: %v, want: %v", res, WrongTypeError)
+	}
+}
+
+func TestFilterReserveLimits(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	tests := []map[string]interface{}{
+		{"cmd": "bf.reserve", "key": "f", "capacity": 0},
+		{"cmd": "bf.reserve", "key": "f", "capacity": maxFilterCapacity + 1},
+		{"cmd": "bf.reserve", "key": "f", "expansion": maxFilterExpansion + 1},
+		{"cmd": "bf.reserve", "key": "f", "capacity": maxFilterCapacity, "error_rate": 1e-9},
+		{"cmd": "cf.reserve", "key": "f", "capacity": 1 << 40},
+		{"cmd": "cf.reserve", "key": "f", "bucket_size": 256},
+		{"cmd": "cf.reserve", "key": "f", "max_kicks": maxCuckooMaxKicks + 1},
+		{"cmd": "cf.reserve", "key": "f", "expansion": -1},
+	}
+	for _, cmd := range tests {
+		res := s.execute(cmd, new(client))
+		if res["code"] != ErrorCodeCommandFormatError {
+			t.Errorf("%v got: %v, want: format error", cmd, res)
+		}
+	}
+	if v, _ := s.Get("f"); v != nil {
+		t.Errorf("got: %v, want: no key", v)
+	}
+}
+
+func TestBloomScaleLimit(t *testing.T) {
+	b := newBloom(1, 1e-6, maxFilterExpansion, false)
+	f := b.filters[0]
+	f.capacity, f.n = maxFilterCapacity, maxFilterCapacity
+	if _, err := b.add([]byte("a")); err != FilterFullError {
+		t.Errorf("got: %v, want: %v", err, FilterFullError)
+	}
+	if len(b.filters) != 1 {
+		t.Errorf("got: %v filters, want: 1", len(b.filters))
+	}
+
+	b = newBloom(1, 0.01, 2, false)
+	b.add([]byte("a"))
+	if _, err := b.add([]byte("b")); err != nil || len(b.filters) != 2 || b.filters[1].capacity != 2 {
+		t.Errorf("got: %v, %v filters, want: 2 filters", err, len(b.filters))
+	}
+}
@@ -1,8 +1,8 @@
 package memds
 
 import (
-	"hash/crc32"
 	"sync"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
@@ -10,11 +10,37 @@ import (
 type Bucket struct {
 	mu    *sync.RWMutex
 	value map[string][]byte
+	// meta holds flags, expiry and cas of keys that have any of them.
+	meta   map[string]*entryMeta
+	casSeq uint64
+	// size is the number of bytes held by keys and encoded values.
+	size int64
+	mh   *codec.MsgpackHandle
+	// indexes are updated on every change of a key, nil if the bucket
+	// belongs to no Store.
+	indexes *indexes
+	// objects holds typed values like streams, a key is either in value
+	// or in objects.
+	objects map[string]interface{}
+}
+
+type entryMeta struct {
+	flags  uint32
+	expire time.Time
+	cas    uint64
+}
+
+func (m *entryMeta) expired(now time.Time) bool {
+	return m != nil && !m.expire.IsZero() && !now.Before(m.expire)
 }
 
 type Buckets []*Bucket
 
 func NewBuckets(n int) (Buckets, error) {
+	return newBuckets(n, &mh)
+}
+
+func newBuckets(n int, h *codec.MsgpackHandle) (Buckets, error) {
 	if n <= 0 {
 		return nil, BucketsLEZeroError
 	}
@@ -23,7 +49,7 @@ func NewBuckets(n int) (Buckets, error) {
 	for i := 0; i < n; i++ {
 		b = append(
 			b,
-			newBucket(),
+			newBucketWithHandle(h),
 		)
 	}
 	return b, nil
@@ -33,9 +59,21 @@ func (b Buckets) Get(k string) *Bucket {
 	if len(b) == 0 {
 		return nil
 	}
-	n := crc32.ChecksumIEEE([]byte(k))
-	i := int(n % uint32(b.Len()))
-	return b[i]
+	return b.get(crc32Hash{}, k)
+}
+
+// get is like Get but picks the bucket with h.
+func (b Buckets) get(h Hash, k string) *Bucket {
+	if len(b) == 0 {
+		return nil
+	}
+	return b[b.index(h, k)]
+}
+
+// index returns the position of the bucket for k. b must not be empty.
+func (b Buckets) index(h Hash, k string) int {
+	n := h.Sum32([]byte(hashTag(k)))
+	return int(n % uint32(b.Len()))
 }
 
 func (b Buckets) Len() int {
@@ -43,21 +81,44 @@ func (b Buckets) Len() int {
 }
 
 func newBucket() *Bucket {
+	return newBucketWithHandle(&mh)
+}
+
+func newBucketWithHandle(h *codec.MsgpackHandle) *Bucket {
 	b := Bucket{
 		mu:    new(sync.RWMutex),
 		value: make(map[string][]byte),
+		meta:  make(map[string]*entryMeta),
+		mh:    h,
 	}
 	return &b
 }
 
+// handle returns the codec the bucket encodes values with, falling back to
+// the package default for buckets built without one.
+func (b *Bucket) handle() *codec.MsgpackHandle {
+	if b.mh == nil {
+		return &mh
+	}
+	return b.mh
+}
+
 func (b *Bucket) Get(k string) (interface{}, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	v, ok := b.value[k]
+	return b.get(k)
+}
+
+// get is Get for callers holding b.mu.
+func (b *Bucket) get(k string) (interface{}, error) {
+	if _, ok := b.object(k, time.Now()); ok {
+		return nil, WrongTypeError
+	}
+	v, _, ok := b.lookup(k, time.Now())
 	if ok {
 		var r interface{}
-		dec := codec.NewDecoderBytes(v, &mh)
+		dec := codec.NewDecoderBytes(v, b.handle())
 		if err := dec.Decode(&r); err != nil {
 			return nil, err
 		}
@@ -67,16 +128,39 @@ func (b *Bucket) Get(k string) (interface{}, error) {
 	}
 }
 
+func (b *Bucket) getRaw(k string) (interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	v, _, ok := b.lookup(k, time.Now())
+	if !ok {
+		return nil, ValueNotFoundError
+	}
+	// Bitmap commands change stored values in place.
+	return rawValue(append([]byte(nil), v...)), nil
+}
+
+// Set stores v encoded as msgpack, a rawValue is stored as is.
 func (b *Bucket) Set(k string, v interface{}) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	return b.set(k, v)
+}
+
+// set is Set for callers holding b.mu for writing.
+func (b *Bucket) set(k string, v interface{}) error {
 	var bs []byte
-	enc := codec.NewEncoderBytes(&bs, &mh)
-	if err := enc.Encode(v); err != nil {
-		return err
+	if r, ok := v.(rawValue); ok {
+		bs = r
+	} else {
+		enc := codec.NewEncoderBytes(&bs, b.handle())
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
 	}
-	b.value[k] = bs
+	b.put(k, bs)
+	delete(b.meta, k)
 	return nil
 }
 
@@ -84,5 +168,128 @@ func (b *Bucket) Del(k string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	delete(b.value, k)
+	b.remove(k)
+}
+
+// exists reports whether k is stored and not expired.
+func (b *Bucket) exists(k string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	_, _, ok := b.lookup(k, time.Now())
+	return ok
+}
+
+// lookup returns the encoded value and meta of k, treating expired keys as
+// missing. The caller must hold b.mu.
+func (b *Bucket) lookup(k string, now time.Time) ([]byte, *entryMeta, bool) {
+	v, ok := b.value[k]
+	if !ok {
+		return nil, nil, false
+	}
+	m := b.meta[k]
+	if m.expired(now) {
+		return nil, nil, false
+	}
+	return v, m, true
+}
+
+// setMeta replaces the meta of k, giving it a new cas. The caller must hold
+// b.mu for writing.
+func (b *Bucket) setMeta(k string, flags uint32, expire time.Time) *entryMeta {
+	if b.meta == nil {
+		b.meta = make(map[string]*entryMeta)
+	}
+	b.casSeq++
+	m := entryMeta{
+		flags:  flags,
+		expire: expire,
+		cas:    b.casSeq,
+	}
+	b.meta[k] = &m
+	return &m
+}
+
+// changed gives k a new cas, keeping its flags and expiry, after its value
+// changed without being replaced. The caller must hold b.mu for writing.
+func (b *Bucket) changed(k string) {
+	if m, ok := b.meta[k]; ok {
+		b.setMeta(k, m.flags, m.expire)
+	}
+}
+
+// DeleteExpired removes keys whose expiry is before now and returns how many
+// were removed.
+func (b *Bucket) DeleteExpired(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for k, m := range b.meta {
+		if m.expired(now) {
+			b.remove(k)
+			n++
+		}
+	}
+	return n
+}
+
+func (b *Bucket) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.indexes.active() {
+		for k := range b.value {
+			b.indexes.remove(k)
+		}
+	}
+	b.value = make(map[string][]byte)
+	b.meta = make(map[string]*entryMeta)
+	b.objects = nil
+	b.size = 0
+}
+
+// Stats returns the number of keys and bytes held by the bucket. Typed
+// values are sized as they are now, which walks their elements.
+func (b *Bucket) Stats() (int, int64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := b.size
+	for k, o := range b.objects {
+		n += int64(len(k))
+		if s, ok := o.(sizer); ok {
+			n += s.size()
+		}
+	}
+	return len(b.value) + len(b.objects), n
+}
+
+// put stores an encoded value and keeps size in step. The caller must hold
+// b.mu for writing.
+func (b *Bucket) put(k string, v []byte) {
+	if old, ok := b.value[k]; ok {
+		b.size -= int64(len(k) + len(old))
+	}
+	delete(b.objects, k)
+	b.value[k] = v
+	b.size += int64(len(k) + len(v))
+	b.indexes.update(k, v, b.handle())
+}
+
+// remove deletes k with its meta and reports whether it was present. The
+// caller must hold b.mu for writing.
+func (b *Bucket) remove(k string) bool {
+	old, ok := b.value[k]
+	if ok {
+		b.size -= int64(len(k) + len(old))
+		delete(b.value, k)
+		b.indexes.remove(k)
+	}
+	if _, found := b.objects[k]; found {
+		delete(b.objects, k)
+		ok = true
+	}
+	delete(b.meta, k)
+	return ok
 }
@@ -0,0 +1,207 @@
+package memds
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client is the connection a command was sent from.
+type client struct {
+	// bytesIn and bytesOut are updated with sync/atomic.
+	bytesIn  uint64
+	bytesOut uint64
+
+	id   uint64
+	addr string
+	conn net.Conn
+	// reader buffers conn, blocked commands read ahead on it to notice a
+	// disconnect.
+	reader  *bufio.Reader
+	created time.Time
+	// streams is true if the connection can be turned into a stream by
+	// commands like monitor.
+	streams bool
+	// monitor is set once the monitor command ran on the connection.
+	monitor *monitor
+	// gate is the read lock of Store.smu held while a command of c runs.
+	gate sync.Locker
+	// done is closed once a client without a connection, like an HTTP
+	// request, goes away.
+	done <-chan struct{}
+	// awaitCommand is called before a memcached command is read and
+	// returns once it starts to arrive. It is nil for clients without a
+	// connection.
+	awaitCommand func() error
+
+	mu       sync.Mutex
+	name     string
+	lastCmd  string
+	lastUsed time.Time
+}
+
+func newClient(c net.Conn) *client {
+	now := time.Now()
+	cl := client{
+		addr:     c.RemoteAddr().String(),
+		conn:     c,
+		created:  now,
+		streams:  true,
+		lastUsed: now,
+	}
+	return &cl
+}
+
+func (c *client) used(cmd string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastCmd = cmd
+	c.lastUsed = t
+}
+
+func (c *client) setName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = name
+}
+
+func (c *client) getName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.name
+}
+
+func (c *client) read(n int) {
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+}
+
+func (c *client) wrote(n int) {
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+}
+
+func (c *client) info(now time.Time) map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]interface{}{
+		"id":        c.id,
+		"addr":      c.addr,
+		"name":      c.name,
+		"age":       int64(now.Sub(c.created).Seconds()),
+		"idle":      int64(now.Sub(c.lastUsed).Seconds()),
+		"cmd":       c.lastCmd,
+		"bytes_in":  atomic.LoadUint64(&c.bytesIn),
+		"bytes_out": atomic.LoadUint64(&c.bytesOut),
+	}
+}
+
+// clients is the registry of connections served by accept.
+type clients struct {
+	mu     sync.RWMutex
+	nextID uint64
+	conns  map[uint64]*client
+}
+
+// add registers c unless max clients are already registered. max 0 means
+// no limit.
+func (cs *clients) add(c *client, max int) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if max > 0 && len(cs.conns) >= max {
+		return false
+	}
+	if cs.conns == nil {
+		cs.conns = make(map[uint64]*client)
+	}
+	cs.nextID++
+	c.id = cs.nextID
+	cs.conns[c.id] = c
+	return true
+}
+
+func (cs *clients) remove(c *client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.conns, c.id)
+}
+
+// list returns the registered clients ordered by id.
+func (cs *clients) list() []*client {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	r := make([]*client, 0, len(cs.conns))
+	for _, c := range cs.conns {
+		r = append(r, c)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].id < r[j].id
+	})
+	return r
+}
+
+// kill closes the connections matching id or addr and returns how many were
+// closed.
+func (cs *clients) kill(id uint64, addr string) int {
+	n := 0
+	for _, c := range cs.list() {
+		if (id != 0 && c.id == id) || (addr != "" && c.addr == addr) {
+			c.conn.Close()
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Store) execClient(cmd map[string]interface{}, c *client) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	switch sub {
+	case "list":
+		now := time.Now()
+		cs := s.clients.list()
+		r := make([]interface{}, 0, len(cs))
+		for _, c := range cs {
+			r = append(r, c.info(now))
+		}
+		return s.response(map[string]interface{}{"value": r})
+	case "kill":
+		id, errRes := s.optionalIntArg(cmd, "id", 0)
+		if errRes != nil {
+			return errRes
+		}
+		addr, errRes := s.optionalStringArg(cmd, "addr")
+		if errRes != nil {
+			return errRes
+		}
+		if id <= 0 && addr == "" {
+			return s.responseCmdFormatError("key 'id' or 'addr' not found")
+		}
+		n := s.clients.kill(uint64(id), addr)
+		if n == 0 {
+			return s.responseCmdExecuteError("no such client")
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "setname":
+		name, errRes := s.stringArg(cmd, "name")
+		if errRes != nil {
+			return errRes
+		}
+		c.setName(name)
+		return s.responseOK()
+	case "getname":
+		return s.response(map[string]interface{}{"value": c.getName()})
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
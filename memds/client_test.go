@@ -0,0 +1,57 @@
+package memds
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientCommands(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	c0, p0 := net.Pipe()
+	defer p0.Close()
+	c1, p1 := net.Pipe()
+	defer p1.Close()
+
+	cl0 := newClient(c0)
+	cl1 := newClient(c1)
+	s.clients.add(cl0, 0)
+	s.clients.add(cl1, 0)
+
+	res := s.execute(map[string]interface{}{"cmd": "client", "sub": "setname", "name": "worker"}, cl0)
+	if res["status"] != true {
+		t.Errorf("got: %v, want: true", res)
+	}
+	res = s.execute(map[string]interface{}{"cmd": "client", "sub": "getname"}, cl0)
+	if res["value"] != "worker" {
+		t.Errorf("got: %v, want: worker", res["value"])
+	}
+
+	res = s.execute(map[string]interface{}{"cmd": "client", "sub": "list"}, cl0)
+	list := res["value"].([]interface{})
+	if len(list) != 2 {
+		t.Fatalf("got: %v, want: 2", len(list))
+	}
+	info := list[0].(map[string]interface{})
+	if info["id"] != cl0.id || info["name"] != "worker" || info["cmd"] != "client" {
+		t.Errorf("got: %v", info)
+	}
+
+	res = s.execute(map[string]interface{}{"cmd": "client", "sub": "kill", "id": int64(cl1.id)}, cl0)
+	if res["value"] != 1 {
+		t.Errorf("got: %v, want: 1", res["value"])
+	}
+	if _, err := c1.Write([]byte("x")); err == nil {
+		t.Error("killed connection should be closed")
+	}
+
+	res = s.execute(map[string]interface{}{"cmd": "client", "sub": "kill", "id": int64(100)}, cl0)
+	if res["status"] != false {
+		t.Errorf("got: %v, want: false", res["status"])
+	}
+
+	s.clients.remove(cl1)
+	if len(s.clients.list()) != 1 {
+		t.Errorf("got: %v, want: 1", len(s.clients.list()))
+	}
+}
@@ -2,125 +2,273 @@ package memds
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
 
 func Exec(b []byte) []byte {
+	return defaultStore.Exec(b)
+}
+
+func (s *Store) Exec(b []byte) []byte {
+	return s.exec(b, new(client))
+}
+
+// exec runs the encoded command b sent by c.
+func (s *Store) exec(b []byte, c *client) []byte {
+	if s.Config().RawValues {
+		if cmd, err := s.decodeRawCommand(b); err == nil {
+			return s.encodeResponse(s.execute(cmd, c))
+		}
+	}
+
 	cmd := make(map[string]interface{})
-	dec := codec.NewDecoderBytes(b, &mh)
+	dec := codec.NewDecoderBytes(b, s.mh)
 	if err := dec.Decode(&cmd); err != nil {
-		return responseCmdDecodeError(err.Error())
+		return s.encodeResponse(s.responseCmdDecodeError(err.Error()))
 	}
+	return s.encodeResponse(s.execute(cmd, c))
+}
 
-	c, ok := cmd["cmd"]
-	if !ok {
-		return responseCmdFormatError(fmt.Sprintf("key 'cmd' not found"))
-	}
-	var cs string
-	switch v := c.(type) {
-	case string:
-		cs = v
-	case []uint8:
-		cs = Uint8ArrayToString(v)
-	default:
-		return responseCmdFormatError(fmt.Sprintf("key 'cmd' not type string"))
+// execute runs cmd sent by c and records it in the store metrics, slowlog
+// and monitors.
+func (s *Store) execute(cmd map[string]interface{}, c *client) map[string]interface{} {
+	spec := s.lookupCommand(commandName(cmd))
+	script := spec != nil && spec.flags&cmdScript != 0
+	return s.run(cmd, metricsCommandName(spec), script, c, func() map[string]interface{} {
+		return s.dispatch(cmd, c)
+	})
+}
+
+// run runs fn for cmd sent by c and records it under name in the store
+// metrics, slowlog and monitors. Unless cmd runs or manages scripts, it
+// waits for a running script.
+func (s *Store) run(cmd map[string]interface{}, name string, script bool, c *client, fn func() map[string]interface{}) map[string]interface{} {
+	start := time.Now()
+	s.publishMonitor(cmd, start, c)
+	var res map[string]interface{}
+	if script {
+		res = fn()
+	} else {
+		// Scripts lock smu to run alone.
+		g := s.smu.RLocker()
+		g.Lock()
+		c.gate = g
+		res = fn()
+		c.gate = nil
+		g.Unlock()
 	}
+	d := time.Since(start)
+	c.used(name, start)
+	s.metrics.observeCommand(name, res, d)
+	s.recordSlow(cmd, name, start, d, c.addr)
+	return res
+}
 
-	switch cs {
-	case "get":
-		k, ok := cmd["key"]
-		if !ok {
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not found"))
-		}
-		var ks string
-		switch v := k.(type) {
-		case string:
-			ks = v
-		case []uint8:
-			ks = Uint8ArrayToString(v)
-		default:
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not type string"))
+func (s *Store) dispatch(cmd map[string]interface{}, c *client) map[string]interface{} {
+	name, errRes := s.stringArg(cmd, "cmd")
+	if errRes != nil {
+		return errRes
+	}
+	spec := s.lookupCommand(name)
+	if spec == nil {
+		return s.responseCmdNotFoundError()
+	}
+	for _, f := range spec.required {
+		if _, ok := cmd[f]; !ok {
+			return s.responseCmdFormatError(fmt.Sprintf("key '%s' not found", f))
 		}
+	}
+	return spec.run(s, name, cmd, c)
+}
 
-		v, err := Get(ks)
-		if err != nil && err != ValueNotFoundError {
-			return responseCmdExecuteError(err.Error())
-		}
+func (s *Store) execGet(cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
 
-		return response(map[string]interface{}{"value": v})
-	case "set":
-		k, ok := cmd["key"]
-		if !ok {
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not found"))
-		}
-		var ks string
-		switch v := k.(type) {
-		case string:
-			ks = v
-		case []uint8:
-			ks = Uint8ArrayToString(v)
-		default:
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not type string"))
-		}
+	var (
+		v   interface{}
+		err error
+	)
+	if s.Config().RawValues {
+		v, err = s.getRaw(k)
+	} else {
+		v, err = s.Get(k)
+	}
+	if err != nil && err != ValueNotFoundError {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.response(map[string]interface{}{"value": v})
+}
 
-		v, ok := cmd["value"]
-		if !ok {
-			return responseCmdFormatError(fmt.Sprintf("key 'value' not found"))
-		}
+func (s *Store) execSet(cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	if err := s.Set(k, cmd["value"]); err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.responseOK()
+}
 
-		err := Set(ks, v)
-		if err != nil {
-			return responseCmdExecuteError(err.Error())
-		}
+func (s *Store) execDel(cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	if err := s.Del(k); err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.responseOK()
+}
+
+func (s *Store) execInfo(cmd map[string]interface{}) map[string]interface{} {
+	section, errRes := s.optionalStringArg(cmd, "section")
+	if errRes != nil {
+		return errRes
+	}
+	info := s.Info(section)
+	if info == nil {
+		return s.responseCmdExecuteError(fmt.Sprintf("info section '%s' not found", section))
+	}
+	return s.response(map[string]interface{}{"info": info})
+}
+
+// commandName returns the name of cmd, or "" if it has none.
+func commandName(cmd map[string]interface{}) string {
+	switch v := cmd["cmd"].(type) {
+	case string:
+		return v
+	case []uint8:
+		return Uint8ArrayToString(v)
+	default:
+		return ""
+	}
+}
+
+// stringArg returns cmd[name] as a string, or a format error response if it
+// is missing or not a string.
+func (s *Store) stringArg(cmd map[string]interface{}, name string) (string, map[string]interface{}) {
+	if _, ok := cmd[name]; !ok {
+		return "", s.responseCmdFormatError(fmt.Sprintf("key '%s' not found", name))
+	}
+	return s.optionalStringArg(cmd, name)
+}
+
+// optionalStringArg is like stringArg but returns "" if cmd[name] is missing.
+func (s *Store) optionalStringArg(cmd map[string]interface{}, name string) (string, map[string]interface{}) {
+	switch v := cmd[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []uint8:
+		return Uint8ArrayToString(v), nil
+	default:
+		return "", s.responseCmdFormatError(fmt.Sprintf("key '%s' not type string", name))
+	}
+}
 
-		return responseOK()
-	case "del":
-		k, ok := cmd["key"]
-		if !ok {
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not found"))
+// stringsArg returns cmd[name] as a list of strings, a single string is a
+// list of one.
+func (s *Store) stringsArg(cmd map[string]interface{}, name string) ([]string, map[string]interface{}) {
+	switch v := cmd[name].(type) {
+	case nil:
+		return nil, s.responseCmdFormatError(fmt.Sprintf("key '%s' not found", name))
+	case string, []uint8:
+		str, errRes := s.stringArg(cmd, name)
+		if errRes != nil {
+			return nil, errRes
 		}
-		var ks string
-		switch v := k.(type) {
-		case string:
-			ks = v
-		case []uint8:
-			ks = Uint8ArrayToString(v)
-		default:
-			return responseCmdFormatError(fmt.Sprintf("key 'key' not type string"))
+		return []string{str}, nil
+	case []interface{}:
+		r := make([]string, 0, len(v))
+		for _, e := range v {
+			switch t := e.(type) {
+			case string:
+				r = append(r, t)
+			case []uint8:
+				r = append(r, Uint8ArrayToString(t))
+			default:
+				return nil, s.responseCmdFormatError(fmt.Sprintf("key '%s' not type string list", name))
+			}
 		}
+		return r, nil
+	default:
+		return nil, s.responseCmdFormatError(fmt.Sprintf("key '%s' not type string list", name))
+	}
+}
 
-		err := Del(ks)
-		if err != nil {
-			return responseCmdExecuteError(err.Error())
+// valuesArg returns the list cmd["values"], or cmd["value"] as a list of one.
+func (s *Store) valuesArg(cmd map[string]interface{}) ([]interface{}, map[string]interface{}) {
+	if v, ok := cmd["values"]; ok {
+		vs, ok := v.([]interface{})
+		if !ok || len(vs) == 0 {
+			return nil, s.responseCmdFormatError("key 'values' not type list")
 		}
-		return responseOK()
-	default:
-		return responseCmdNotFoundError()
+		return vs, nil
 	}
+	if _, ok := cmd["value"]; !ok {
+		return nil, s.responseCmdFormatError("key 'values' not found")
+	}
+	v, errRes := s.valueArg(cmd)
+	if errRes != nil {
+		return nil, errRes
+	}
+	return []interface{}{v}, nil
 }
 
-func Get(k string) (interface{}, error) {
-	b := buckets.Get(k)
-	if b == nil {
-		return nil, BucketNotFoundError
+// valueArg returns cmd["value"]. In raw mode it is decoded from the msgpack
+// bytes the client sent, only set stores them as they are.
+func (s *Store) valueArg(cmd map[string]interface{}) (interface{}, map[string]interface{}) {
+	v, ok := cmd["value"]
+	if !ok {
+		return nil, s.responseCmdFormatError("key 'value' not found")
+	}
+	if r, ok := v.(rawValue); ok {
+		d, err := r.decode(s.mh)
+		if err != nil {
+			return nil, s.responseCmdFormatError(err.Error())
+		}
+		return d, nil
 	}
-	return b.Get(k)
+	return v, nil
 }
 
-func Set(k string, v interface{}) error {
-	b := buckets.Get(k)
-	if b == nil {
-		return BucketNotFoundError
+// floatValue returns v as a float64 if it is a number.
+func floatValue(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	default:
+		return 0, false
 	}
-	return b.Set(k, v)
 }
 
-func Del(k string) error {
-	b := buckets.Get(k)
-	if b == nil {
-		return BucketNotFoundError
+// optionalIntArg returns cmd[name] as an int64, or def if it is missing.
+func (s *Store) optionalIntArg(cmd map[string]interface{}, name string, def int64) (int64, map[string]interface{}) {
+	switch v := cmd[name].(type) {
+	case nil:
+		return def, nil
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	default:
+		return 0, s.responseCmdFormatError(fmt.Sprintf("key '%s' not type int", name))
 	}
-	b.Del(k)
-	return nil
 }
@@ -0,0 +1,262 @@
+package memds
+
+import "sort"
+
+// commandFlag describes how a command behaves.
+type commandFlag uint
+
+const (
+	// cmdWrite commands change keys or the store.
+	cmdWrite commandFlag = 1 << iota
+	// cmdBlocking commands may wait for other clients.
+	cmdBlocking
+	// cmdAdmin commands manage the server rather than keys.
+	cmdAdmin
+	// cmdNoScript commands can't be called from scripts.
+	cmdNoScript
+	// cmdScript commands run or manage scripts, running scripts don't hold
+	// them back.
+	cmdScript
+)
+
+var commandFlagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{cmdBlocking, "blocking"},
+	{cmdAdmin, "admin"},
+	{cmdNoScript, "noscript"},
+	{cmdScript, "script"},
+}
+
+// names returns the names of f, "write" or "readonly" first.
+func (f commandFlag) names() []string {
+	names := []string{"readonly"}
+	if f&cmdWrite != 0 {
+		names[0] = "write"
+	}
+	for _, n := range commandFlagNames {
+		if f&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// commandFunc runs the command name.
+type commandFunc func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{}
+
+// commandSpec describes a command of the command table.
+type commandSpec struct {
+	name string
+	// required are the fields the command can't run without.
+	required []string
+	// keys are the fields naming the keys the command uses, a field is a key
+	// or a list of keys.
+	keys  []string
+	flags commandFlag
+	run   commandFunc
+}
+
+// commandKeys returns the keys cmd names in the key fields of c.
+func (c *commandSpec) commandKeys(cmd map[string]interface{}) []string {
+	var keys []string
+	for _, name := range c.keys {
+		switch v := cmd[name].(type) {
+		case string:
+			keys = append(keys, v)
+		case []uint8:
+			keys = append(keys, Uint8ArrayToString(v))
+		case []interface{}:
+			for _, e := range v {
+				switch k := e.(type) {
+				case string:
+					keys = append(keys, k)
+				case []uint8:
+					keys = append(keys, Uint8ArrayToString(k))
+				}
+			}
+		}
+	}
+	return keys
+}
+
+func (c *commandSpec) info() map[string]interface{} {
+	required := c.required
+	if required == nil {
+		required = []string{}
+	}
+	keys := c.keys
+	if keys == nil {
+		keys = []string{}
+	}
+	return map[string]interface{}{
+		"name":     c.name,
+		"required": required,
+		"keys":     keys,
+		"flags":    c.flags.names(),
+	}
+}
+
+// withName adapts the handler of a group of commands that don't need the
+// client.
+func withName(f func(s *Store, name string, cmd map[string]interface{}) map[string]interface{}) commandFunc {
+	return func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+		return f(s, name, cmd)
+	}
+}
+
+// withCmd adapts the handler of a single command that doesn't need the
+// client.
+func withCmd(f func(s *Store, cmd map[string]interface{}) map[string]interface{}) commandFunc {
+	return func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+		return f(s, cmd)
+	}
+}
+
+var (
+	keyField  = []string{"key"}
+	keysField = []string{"keys"}
+)
+
+// commandTable holds the built in commands by name.
+var commandTable = map[string]*commandSpec{}
+
+func init() {
+	for _, c := range []*commandSpec{
+		{name: "get", required: keyField, keys: keyField, run: withCmd((*Store).execGet)},
+		{name: "set", required: []string{"key", "value"}, keys: keyField, flags: cmdWrite, run: withCmd((*Store).execSet)},
+		{name: "del", required: keyField, keys: keyField, flags: cmdWrite, run: withCmd((*Store).execDel)},
+		{name: "info", run: withCmd((*Store).execInfo)},
+		{name: "config", required: []string{"sub"}, flags: cmdWrite | cmdAdmin, run: withCmd((*Store).execConfig)},
+		{name: "slowlog", required: []string{"sub"}, flags: cmdWrite | cmdAdmin, run: withCmd((*Store).execSlowlog)},
+		{name: "monitor", flags: cmdAdmin | cmdNoScript, run: func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+			return s.execMonitor(c)
+		}},
+		{name: "client", required: []string{"sub"}, flags: cmdWrite | cmdAdmin | cmdNoScript, run: func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+			return s.execClient(cmd, c)
+		}},
+		{name: "resize", required: []string{"sub"}, flags: cmdWrite | cmdAdmin, run: withCmd((*Store).execResize)},
+		{name: "index", required: []string{"sub"}, flags: cmdWrite, run: withCmd((*Store).execIndex)},
+		{name: "command", required: []string{"sub"}, run: withCmd((*Store).execCommand)},
+
+		{name: "xadd", required: []string{"key", "fields"}, keys: keyField, flags: cmdWrite, run: (*Store).execStream},
+		{name: "xrange", required: keyField, keys: keyField, run: (*Store).execStream},
+		{name: "xrevrange", required: keyField, keys: keyField, run: (*Store).execStream},
+		{name: "xlen", required: keyField, keys: keyField, run: (*Store).execStream},
+		{name: "xtrim", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execStream},
+		{name: "xread", required: []string{"keys", "ids"}, keys: keysField, flags: cmdBlocking, run: (*Store).execStream},
+		{name: "xgroup", required: []string{"key", "sub", "group"}, keys: keyField, flags: cmdWrite, run: (*Store).execStream},
+		{name: "xreadgroup", required: []string{"keys", "group", "consumer"}, keys: keysField, flags: cmdWrite | cmdBlocking, run: (*Store).execStream},
+		{name: "xack", required: []string{"key", "group", "ids"}, keys: keyField, flags: cmdWrite, run: (*Store).execStream},
+		{name: "xpending", required: []string{"key", "group"}, keys: keyField, run: (*Store).execStream},
+		{name: "xclaim", required: []string{"key", "group", "consumer", "ids"}, keys: keyField, flags: cmdWrite, run: (*Store).execStream},
+
+		{name: "lpush", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execList},
+		{name: "rpush", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execList},
+		{name: "lpop", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execList},
+		{name: "rpop", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execList},
+		{name: "llen", required: keyField, keys: keyField, run: (*Store).execList},
+		{name: "lrange", required: keyField, keys: keyField, run: (*Store).execList},
+		{name: "blpop", required: keysField, keys: keysField, flags: cmdWrite | cmdBlocking | cmdNoScript, run: (*Store).execList},
+		{name: "brpop", required: keysField, keys: keysField, flags: cmdWrite | cmdBlocking | cmdNoScript, run: (*Store).execList},
+
+		{name: "zadd", required: []string{"key", "members"}, keys: keyField, flags: cmdWrite, run: (*Store).execZset},
+		{name: "zrem", required: []string{"key", "members"}, keys: keyField, flags: cmdWrite, run: (*Store).execZset},
+		{name: "zscore", required: []string{"key", "member"}, keys: keyField, run: (*Store).execZset},
+		{name: "zcard", required: keyField, keys: keyField, run: (*Store).execZset},
+		{name: "zrange", required: keyField, keys: keyField, run: (*Store).execZset},
+		{name: "zrangebyscore", required: keyField, keys: keyField, run: (*Store).execZset},
+		{name: "zpopmin", required: keyField, keys: keyField, flags: cmdWrite, run: (*Store).execZset},
+		{name: "bzpopmin", required: keysField, keys: keysField, flags: cmdWrite | cmdBlocking | cmdNoScript, run: (*Store).execZset},
+
+		{name: "setbit", required: []string{"key", "offset", "bit"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execBitmap)},
+		{name: "getbit", required: []string{"key", "offset"}, keys: keyField, run: withName((*Store).execBitmap)},
+		{name: "bitcount", required: keyField, keys: keyField, run: withName((*Store).execBitmap)},
+		{name: "bitpos", required: []string{"key", "bit"}, keys: keyField, run: withName((*Store).execBitmap)},
+		{name: "bitop", required: []string{"op", "dest", "keys"}, keys: []string{"dest", "keys"}, flags: cmdWrite, run: withName((*Store).execBitmap)},
+		{name: "bitfield", required: []string{"key", "ops"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execBitmap)},
+
+		{name: "pfadd", required: keyField, keys: keyField, flags: cmdWrite, run: withName((*Store).execHLL)},
+		{name: "pfcount", required: keysField, keys: keysField, run: withName((*Store).execHLL)},
+		{name: "pfmerge", required: []string{"dest", "keys"}, keys: []string{"dest", "keys"}, flags: cmdWrite, run: withName((*Store).execHLL)},
+
+		{name: "bf.reserve", required: keyField, keys: keyField, flags: cmdWrite, run: withName((*Store).execBloom)},
+		{name: "bf.add", required: []string{"key", "item"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execBloom)},
+		{name: "bf.madd", required: []string{"key", "items"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execBloom)},
+		{name: "bf.exists", required: []string{"key", "item"}, keys: keyField, run: withName((*Store).execBloom)},
+		{name: "bf.mexists", required: []string{"key", "items"}, keys: keyField, run: withName((*Store).execBloom)},
+		{name: "bf.info", required: keyField, keys: keyField, run: withName((*Store).execBloom)},
+
+		{name: "cf.reserve", required: keyField, keys: keyField, flags: cmdWrite, run: withName((*Store).execCuckoo)},
+		{name: "cf.add", required: []string{"key", "item"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execCuckoo)},
+		{name: "cf.addnx", required: []string{"key", "item"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execCuckoo)},
+		{name: "cf.exists", required: []string{"key", "item"}, keys: keyField, run: withName((*Store).execCuckoo)},
+		{name: "cf.count", required: []string{"key", "item"}, keys: keyField, run: withName((*Store).execCuckoo)},
+		{name: "cf.del", required: []string{"key", "item"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execCuckoo)},
+		{name: "cf.info", required: keyField, keys: keyField, run: withName((*Store).execCuckoo)},
+
+		{name: "geoadd", required: []string{"key", "members"}, keys: keyField, flags: cmdWrite, run: withName((*Store).execGeo)},
+		{name: "geopos", required: []string{"key", "members"}, keys: keyField, run: withName((*Store).execGeo)},
+		{name: "geodist", required: []string{"key", "members"}, keys: keyField, run: withName((*Store).execGeo)},
+		{name: "geosearch", required: keyField, keys: keyField, run: withName((*Store).execGeo)},
+
+		{name: "eval", required: []string{"script"}, keys: keysField, flags: cmdWrite | cmdNoScript | cmdScript, run: (*Store).execScript},
+		{name: "evalsha", required: []string{"sha"}, keys: keysField, flags: cmdWrite | cmdNoScript | cmdScript, run: (*Store).execScript},
+		{name: "script", required: []string{"sub"}, flags: cmdAdmin | cmdNoScript | cmdScript, run: (*Store).execScript},
+	} {
+		commandTable[c.name] = c
+	}
+}
+
+// lookupCommand returns the built in or registered command name, or nil.
+func (s *Store) lookupCommand(name string) *commandSpec {
+	if c, ok := commandTable[name]; ok {
+		return c
+	}
+	return s.commands.get(name)
+}
+
+func (s *Store) execCommand(cmd map[string]interface{}) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+
+	switch sub {
+	case "count":
+		return s.response(map[string]interface{}{"count": len(s.commandNames())})
+	case "list":
+		return s.response(map[string]interface{}{"commands": s.commandNames()})
+	case "info":
+		var names []string
+		if _, ok := cmd["names"]; ok {
+			if names, errRes = s.stringsArg(cmd, "names"); errRes != nil {
+				return errRes
+			}
+		} else {
+			names = s.commandNames()
+		}
+		infos := make([]interface{}, len(names))
+		for i, name := range names {
+			if c := s.lookupCommand(name); c != nil {
+				infos[i] = c.info()
+			}
+		}
+		return s.response(map[string]interface{}{"commands": infos})
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// commandNames returns the names of the built in and registered commands in
+// order.
+func (s *Store) commandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	names = append(names, s.commands.names()...)
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,110 @@
+package memds
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandTable(t *testing.T) {
+	for name, c := range commandTable {
+		if c.name != name {
+			t.Errorf("got: %v, want: %v", c.name, name)
+		}
+		if c.run == nil {
+			t.Errorf("%s got: nil handler, want: handler", name)
+		}
+	}
+}
+
+func TestCommandRequired(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8})
+
+	tests := []struct {
+		cmd map[string]interface{}
+		msg string
+	}{
+		{map[string]interface{}{"cmd": "set", "key": "a"}, "key 'value' not found"},
+		{map[string]interface{}{"cmd": "get"}, "key 'key' not found"},
+		{map[string]interface{}{"cmd": "zadd", "key": "z"}, "key 'members' not found"},
+		{map[string]interface{}{"cmd": "bitop", "op": "and", "keys": "a"}, "key 'dest' not found"},
+		{map[string]interface{}{"cmd": "get", "key": 1}, "key 'key' not type string"},
+	}
+	for _, tt := range tests {
+		res := s.execute(tt.cmd, new(client))
+		if res["code"] != ErrorCodeCommandFormatError || res["msg"] != tt.msg {
+			t.Errorf("%v got: %v, want: %v", tt.cmd, res, tt.msg)
+		}
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8})
+	s.RegisterCommandInfo("incr", CommandInfo{Required: []string{"key"}, Keys: []string{"key"}}, incr)
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	res := exec(map[string]interface{}{"cmd": "command", "sub": "count"})
+	if res["count"] != len(commandTable)+1 {
+		t.Errorf("got: %v, want: %v", res["count"], len(commandTable)+1)
+	}
+	res = exec(map[string]interface{}{"cmd": "command", "sub": "list"})
+	names, _ := res["commands"].([]string)
+	if len(names) != len(commandTable)+1 || names[0] != "bf.add" {
+		t.Errorf("got: %v, want: sorted command names", names)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "command", "sub": "info", "names": []interface{}{"bitop", "get", "blpop", "incr", "nope"}})
+	want := []interface{}{
+		map[string]interface{}{
+			"name":     "bitop",
+			"required": []string{"op", "dest", "keys"},
+			"keys":     []string{"dest", "keys"},
+			"flags":    []string{"write"},
+		},
+		map[string]interface{}{
+			"name":     "get",
+			"required": []string{"key"},
+			"keys":     []string{"key"},
+			"flags":    []string{"readonly"},
+		},
+		map[string]interface{}{
+			"name":     "blpop",
+			"required": []string{"keys"},
+			"keys":     []string{"keys"},
+			"flags":    []string{"write", "blocking", "noscript"},
+		},
+		map[string]interface{}{
+			"name":     "incr",
+			"required": []string{"key"},
+			"keys":     []string{"key"},
+			"flags":    []string{"write"},
+		},
+		nil,
+	}
+	if !reflect.DeepEqual(res["commands"], want) {
+		t.Errorf("got: %v, want: %v", res["commands"], want)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "command", "sub": "nope"})
+	if res["code"] != ErrorCodeCommandNotFoundError {
+		t.Errorf("got: %v, want: command not found", res)
+	}
+}
+
+func TestCommandInfoScriptKeys(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8})
+	s.RegisterCommandInfo("incr", CommandInfo{Keys: []string{"key"}}, incr)
+	s.RegisterCommandInfo("peek", CommandInfo{ReadOnly: true}, func(s *Store, cmd map[string]interface{}) map[string]interface{} {
+		return s.ResponseOK()
+	})
+
+	res := s.execute(map[string]interface{}{"cmd": "eval", "script": `return memds.call({cmd = "incr", key = "n"})`}, new(client))
+	if res["code"] != ErrorCodeCommandExecuteError {
+		t.Errorf("got: %v, want: %v", res, ScriptKeyError)
+	}
+	res = s.execute(map[string]interface{}{"cmd": "eval", "script": `return memds.call({cmd = "peek", key = "n"})`}, new(client))
+	if res["status"] != true {
+		t.Errorf("got: %v, want: ok", res)
+	}
+}
@@ -11,7 +11,7 @@ import (
 )
 
 func TestGetAndSet(t *testing.T) {
-	buckets, _ = NewBuckets(10)
+	defaultStore.buckets, _ = NewBuckets(10)
 
 	setTestCase := []struct {
 		Key   string
@@ -89,7 +89,7 @@ func TestGetAndSet(t *testing.T) {
 		}
 	}
 
-	buckets = make(Buckets, 0, 10)
+	defaultStore.buckets = make(Buckets, 0, 10)
 	err := Set("key", []byte("value"))
 	if err != BucketNotFoundError {
 		t.Errorf("got: %v, want: %v", err, BucketNotFoundError)
@@ -104,7 +104,7 @@ func TestGetAndSet(t *testing.T) {
 }
 
 func TestDel(t *testing.T) {
-	buckets, _ = NewBuckets(10)
+	defaultStore.buckets, _ = NewBuckets(10)
 
 	Set("key", []byte("value"))
 
@@ -123,7 +123,7 @@ func TestDel(t *testing.T) {
 		t.Errorf("got: %v, want: nil", err)
 	}
 
-	buckets = make(Buckets, 0, 10)
+	defaultStore.buckets = make(Buckets, 0, 10)
 	err = Del("key")
 	if err != BucketNotFoundError {
 		t.Errorf("got: %v, want: %v", err, BucketNotFoundError)
@@ -139,7 +139,7 @@ func BenchmarkSet(b *testing.B) {
 		v[s] = []byte(s)
 	}
 
-	buckets, _ = NewBuckets(10)
+	defaultStore.buckets, _ = NewBuckets(10)
 	b.ResetTimer()
 	for k, v := range v {
 		Set(k, v)
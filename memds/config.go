@@ -1,11 +1,175 @@
 package memds
 
-import "github.com/BurntSushi/toml"
+import (
+	"encoding"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
 
 type Config struct {
-	Port      int    `toml:"port"`
-	Sock      string `toml:"sock"`
-	BucketNum int    `toml:"bucket_num"`
+	Port          int    `toml:"port"`
+	Sock          string `toml:"sock"`
+	BucketNum     int    `toml:"bucket_num"`
+	HTTPPort      int    `toml:"http_port"`
+	MemcachedPort int    `toml:"memcached_port"`
+	MetricsPort   int    `toml:"metrics_port"`
+	// Hash picks buckets for keys: crc32 (default), xxhash, fnv-1a or murmur3.
+	Hash string `toml:"hash"`
+
+	SlowlogThreshold Duration `toml:"slowlog_threshold"`
+	SlowlogMaxLen    int      `toml:"slowlog_max_len"`
+
+	// MaxClients limits open connections, 0 means no limit.
+	MaxClients int `toml:"max_clients"`
+	// IdleTimeout closes connections that send no command for this long.
+	IdleTimeout Duration `toml:"idle_timeout"`
+	// ReadTimeout bounds reading a command once it started to arrive.
+	ReadTimeout Duration `toml:"read_timeout"`
+	// WriteTimeout bounds writing a response.
+	WriteTimeout Duration `toml:"write_timeout"`
+	// ShutdownTimeout bounds the drain on SIGINT and SIGTERM.
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+
+	// RawValues stores values as the msgpack bytes clients send and writes
+	// them back as is, skipping the codec for get and set.
+	RawValues bool `toml:"raw_values"`
+
+	// ScriptTimeout stops scripts running longer, 5s if 0.
+	ScriptTimeout Duration `toml:"script_timeout"`
+
+	// LogLevel is one of debug, info, warn or error.
+	LogLevel string `toml:"log_level"`
+
+	// file is the path the config was loaded from.
+	file string
+}
+
+// reloadableConfig are the settings applied without a restart by SIGHUP and
+// config set.
+var reloadableConfig = map[string]bool{
+	"slowlog_threshold": true,
+	"slowlog_max_len":   true,
+	"max_clients":       true,
+	"idle_timeout":      true,
+	"read_timeout":      true,
+	"write_timeout":     true,
+	"shutdown_timeout":  true,
+	"log_level":         true,
+	"raw_values":        true,
+	"script_timeout":    true,
+}
+
+// Duration is a time.Duration written as a string like "10ms" in toml.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Map returns the config as a map keyed by toml names. If pattern is not
+// empty only names matching it are returned.
+func (c *Config) Map(pattern string) map[string]interface{} {
+	m := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("toml")
+		if name == "" {
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, name); !ok {
+				continue
+			}
+		}
+		f := v.Field(i).Interface()
+		if tm, ok := f.(encoding.TextMarshaler); ok {
+			b, _ := tm.MarshalText()
+			f = string(b)
+		}
+		m[name] = f
+	}
+	return m
+}
+
+// Set parses value into the setting named by its toml name.
+func (c *Config) Set(name, value string) error {
+	f, ok := c.field(name)
+	if !ok {
+		return fmt.Errorf("unknown config: %s", name)
+	}
+	switch p := f.Addr().Interface().(type) {
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *string:
+		*p = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case encoding.TextUnmarshaler:
+		return p.UnmarshalText([]byte(value))
+	default:
+		return fmt.Errorf("config %s can't be set", name)
+	}
+	return nil
+}
+
+// Diff returns the toml names of the settings that differ between c and o.
+func (c *Config) Diff(o *Config) []string {
+	var names []string
+	v := reflect.ValueOf(c).Elem()
+	ov := reflect.ValueOf(o).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("toml")
+		if name == "" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), ov.Field(i).Interface()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// copyField sets the setting named by its toml name to the value in o.
+func (c *Config) copyField(name string, o *Config) {
+	f, ok := c.field(name)
+	of, _ := o.field(name)
+	if ok {
+		f.Set(of)
+	}
+}
+
+func (c *Config) field(name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
 }
 
 func LoadConfig(p string) (*Config, error) {
@@ -13,5 +177,6 @@ func LoadConfig(p string) (*Config, error) {
 	if _, err := toml.DecodeFile(p, &c); err != nil {
 		return nil, err
 	}
+	c.file = p
 	return &c, nil
 }
@@ -0,0 +1,353 @@
+package memds
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const (
+	defaultCuckooCapacity   = 1024
+	defaultCuckooBucketSize = 2
+	defaultCuckooMaxKicks   = 20
+	maxCuckooMaxKicks       = 1024
+)
+
+// cuckooTable is one cuckoo filter of 1 byte fingerprints, 0 is an empty
+// slot.
+type cuckooTable struct {
+	slots      []byte
+	buckets    uint64
+	bucketSize int
+}
+
+func newCuckooTable(capacity, bucketSize int) *cuckooTable {
+	n := uint64(1)
+	for n*uint64(bucketSize) < uint64(capacity) {
+		n <<= 1
+	}
+	t := cuckooTable{
+		slots:      make([]byte, n*uint64(bucketSize)),
+		buckets:    n,
+		bucketSize: bucketSize,
+	}
+	return &t
+}
+
+// cuckooFingerprint returns the fingerprint of an item hashed to h.
+func cuckooFingerprint(h uint64) byte {
+	fp := byte(h >> 56)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp
+}
+
+// index returns the two buckets a fingerprint of an item hashed to h may be
+// stored in. Each is the other xor a hash of the fingerprint.
+func (t *cuckooTable) index(h uint64, fp byte) (uint64, uint64) {
+	i := h & (t.buckets - 1)
+	return i, t.alt(i, fp)
+}
+
+func (t *cuckooTable) alt(i uint64, fp byte) uint64 {
+	return (i ^ murmur64a([]byte{fp}, 0)) & (t.buckets - 1)
+}
+
+func (t *cuckooTable) bucket(i uint64) []byte {
+	return t.slots[i*uint64(t.bucketSize) : (i+1)*uint64(t.bucketSize)]
+}
+
+func (t *cuckooTable) count(h uint64, fp byte) int {
+	i1, i2 := t.index(h, fp)
+	n := 0
+	for _, i := range []uint64{i1, i2} {
+		for _, s := range t.bucket(i) {
+			if s == fp {
+				n++
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+	return n
+}
+
+func (t *cuckooTable) del(h uint64, fp byte) bool {
+	i1, i2 := t.index(h, fp)
+	for _, i := range []uint64{i1, i2} {
+		b := t.bucket(i)
+		for j, s := range b {
+			if s == fp {
+				b[j] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *cuckooTable) put(i uint64, fp byte) bool {
+	b := t.bucket(i)
+	for j, s := range b {
+		if s == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// add stores fp, moving up to maxKicks other fingerprints to their other
+// bucket to make room. If that fails the moves are undone and it returns
+// false.
+func (t *cuckooTable) add(h uint64, fp byte, maxKicks int) bool {
+	i1, i2 := t.index(h, fp)
+	if t.put(i1, fp) || t.put(i2, fp) {
+		return true
+	}
+
+	type kick struct {
+		slot int
+		fp   byte
+	}
+	var kicks []kick
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for n := 0; n < maxKicks; n++ {
+		slot := int(i)*t.bucketSize + rand.Intn(t.bucketSize)
+		kicks = append(kicks, kick{slot: slot, fp: t.slots[slot]})
+		fp, t.slots[slot] = t.slots[slot], fp
+		i = t.alt(i, fp)
+		if t.put(i, fp) {
+			return true
+		}
+	}
+	for n := len(kicks) - 1; n >= 0; n-- {
+		t.slots[kicks[n].slot] = kicks[n].fp
+	}
+	return false
+}
+
+// cuckoo is a cuckoo filter. Unlike a bloom filter items can be deleted.
+// Once an item doesn't fit a table expansion times larger is added.
+type cuckoo struct {
+	tables     []*cuckooTable
+	capacity   int
+	bucketSize int
+	maxKicks   int
+	expansion  int
+	n          int
+	deleted    int
+}
+
+func newCuckoo(capacity, bucketSize, maxKicks, expansion int) *cuckoo {
+	c := cuckoo{
+		capacity:   capacity,
+		bucketSize: bucketSize,
+		maxKicks:   maxKicks,
+		expansion:  expansion,
+	}
+	c.tables = []*cuckooTable{newCuckooTable(capacity, bucketSize)}
+	return &c
+}
+
+func newDefaultCuckoo() interface{} {
+	return newCuckoo(defaultCuckooCapacity, defaultCuckooBucketSize, defaultCuckooMaxKicks, defaultFilterExpand)
+}
+
+// add stores e, adding a table if it doesn't fit in the last one. It
+// returns CuckooFullError if e doesn't fit in the new table either.
+func (c *cuckoo) add(e []byte) error {
+	h, _ := filterHashes(e)
+	fp := cuckooFingerprint(h)
+	t := c.tables[len(c.tables)-1]
+	if !t.add(h, fp, c.maxKicks) {
+		size := len(t.slots) * c.expansion
+		if size > maxFilterCapacity {
+			size = maxFilterCapacity
+		}
+		t = newCuckooTable(size, c.bucketSize)
+		if !t.add(h, fp, c.maxKicks) {
+			return CuckooFullError
+		}
+		c.tables = append(c.tables, t)
+	}
+	c.n++
+	return nil
+}
+
+// count returns how many times e may have been added and not deleted.
+func (c *cuckoo) count(e []byte) int {
+	h, _ := filterHashes(e)
+	fp := cuckooFingerprint(h)
+	n := 0
+	for _, t := range c.tables {
+		n += t.count(h, fp)
+	}
+	return n
+}
+
+// del deletes one of the times e was added. Deleting an item that wasn't
+// added may delete another one sharing its fingerprint.
+func (c *cuckoo) del(e []byte) bool {
+	h, _ := filterHashes(e)
+	fp := cuckooFingerprint(h)
+	for i := len(c.tables) - 1; i >= 0; i-- {
+		if c.tables[i].del(h, fp) {
+			c.n--
+			c.deleted++
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cuckoo) size() int64 {
+	var n int64
+	for _, t := range c.tables {
+		n += int64(len(t.slots))
+	}
+	return n
+}
+
+func (c *cuckoo) info() map[string]interface{} {
+	size, buckets := 0, uint64(0)
+	for _, t := range c.tables {
+		size += len(t.slots)
+		buckets += t.buckets
+	}
+	return map[string]interface{}{
+		"size":        size,
+		"buckets":     buckets,
+		"filters":     len(c.tables),
+		"items":       c.n,
+		"deleted":     c.deleted,
+		"bucket_size": c.bucketSize,
+		"expansion":   c.expansion,
+		"max_kicks":   c.maxKicks,
+	}
+}
+
+// cuckoo returns the cuckoo filter at k, creating one with the default
+// capacity if create is set. It returns nil if k doesn't exist. The caller
+// must hold b.mu, for writing if create is set.
+func (b *Bucket) cuckoo(k string, create bool) (*cuckoo, error) {
+	var f func() interface{}
+	if create {
+		f = newDefaultCuckoo
+	}
+	o, err := b.typed(k, f)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	c, ok := o.(*cuckoo)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return c, nil
+}
+
+func (s *Store) execCuckoo(name string, cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	var item []byte
+	if name != "cf.reserve" && name != "cf.info" {
+		str, errRes := s.stringArg(cmd, "item")
+		if errRes != nil {
+			return errRes
+		}
+		item = []byte(str)
+	}
+
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		if name == "cf.reserve" {
+			res = s.execCuckooReserve(b, k, cmd)
+			return nil
+		}
+
+		c, err := b.cuckoo(k, name == "cf.add" || name == "cf.addnx")
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		switch name {
+		case "cf.add":
+			if err := c.add(item); err != nil {
+				return err
+			}
+			v = 1
+		case "cf.addnx":
+			added := c.count(item) == 0
+			if added {
+				if err := c.add(item); err != nil {
+					return err
+				}
+			}
+			v = boolInt(added)
+		case "cf.exists":
+			v = boolInt(c != nil && c.count(item) > 0)
+		case "cf.count":
+			n := 0
+			if c != nil {
+				n = c.count(item)
+			}
+			v = n
+		case "cf.del":
+			if c == nil {
+				return ValueNotFoundError
+			}
+			v = boolInt(c.del(item))
+		case "cf.info":
+			if c == nil {
+				return ValueNotFoundError
+			}
+			v = c.info()
+		default:
+			res = s.responseCmdNotFoundError()
+			return nil
+		}
+		res = s.response(map[string]interface{}{"value": v})
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return res
+}
+
+func (s *Store) execCuckooReserve(b *Bucket, k string, cmd map[string]interface{}) map[string]interface{} {
+	capacity, expansion, errRes := s.filterOptionsArg(cmd, defaultCuckooCapacity)
+	if errRes != nil {
+		return errRes
+	}
+	bucketSize, errRes := s.optionalIntArg(cmd, "bucket_size", defaultCuckooBucketSize)
+	if errRes != nil {
+		return errRes
+	}
+	if bucketSize < 1 || bucketSize > 255 {
+		return s.responseCmdFormatError("key 'bucket_size' not between 1 and 255")
+	}
+	maxKicks, errRes := s.optionalIntArg(cmd, "max_kicks", defaultCuckooMaxKicks)
+	if errRes != nil {
+		return errRes
+	}
+	if maxKicks < 1 || maxKicks > maxCuckooMaxKicks {
+		return s.responseCmdFormatError(fmt.Sprintf("key 'max_kicks' not between 1 and %d", maxCuckooMaxKicks))
+	}
+
+	o, err := b.typed(k, nil)
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if o != nil {
+		return s.responseCmdExecuteError(FilterExistsError.Error())
+	}
+	b.setObject(k, newCuckoo(capacity, int(bucketSize), int(maxKicks), expansion))
+	return s.responseOK()
+}
@@ -0,0 +1,126 @@
+package memds
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCuckoo(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	testCase := []struct {
+		Cmd   map[string]interface{}
+		Value interface{}
+	}{
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.add", "key": "c", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.add", "key": "c", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.addnx", "key": "c", "item": "a"},
+			Value: 0,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.count", "key": "c", "item": "a"},
+			Value: 2,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.del", "key": "c", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.exists", "key": "c", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.del", "key": "c", "item": "a"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.exists", "key": "c", "item": "a"},
+			Value: 0,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.del", "key": "c", "item": "a"},
+			Value: 0,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "cf.exists", "key": "missing", "item": "a"},
+			Value: 0,
+		},
+	}
+	for _, tc := range testCase {
+		if res := exec(tc.Cmd); !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res, tc.Value)
+		}
+	}
+
+	res := exec(map[string]interface{}{"cmd": "cf.reserve", "key": "ids", "capacity": 1000, "bucket_size": 4})
+	if res["status"] != true {
+		t.Fatalf("got: %v", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "cf.reserve", "key": "ids"})
+	if res["msg"] != FilterExistsError.Error() {
+		t.Errorf("got: %v, want: %v", res, FilterExistsError)
+	}
+
+	// Adding 10 times the capacity adds tables.
+	n := 10000
+	for i := 0; i < n; i++ {
+		exec(map[string]interface{}{"cmd": "cf.add", "key": "ids", "item": fmt.Sprintf("id:%d", i)})
+	}
+	for i := 0; i < n; i++ {
+		res := exec(map[string]interface{}{"cmd": "cf.exists", "key": "ids", "item": fmt.Sprintf("id:%d", i)})
+		if res["value"] != 1 {
+			t.Fatalf("id:%d got: %v, want: 1", i, res)
+		}
+	}
+	info := exec(map[string]interface{}{"cmd": "cf.info", "key": "ids"})["value"].(map[string]interface{})
+	if info["items"] != n || info["filters"].(int) < 2 {
+		t.Errorf("got: %v", info)
+	}
+
+	for i := 0; i < n/2; i++ {
+		exec(map[string]interface{}{"cmd": "cf.del", "key": "ids", "item": fmt.Sprintf("id:%d", i)})
+	}
+	fp := 0
+	for i := 0; i < n/2; i++ {
+		res := exec(map[string]interface{}{"cmd": "cf.exists", "key": "ids", "item": fmt.Sprintf("id:%d", i)})
+		fp += res["value"].(int)
+	}
+	if rate := float64(fp) / float64(n/2); rate > 0.1 {
+		t.Errorf("got: false positive rate %v after delete, want: at most 0.1", rate)
+	}
+}
+
+func TestCuckooAddSame(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	// The same item fills both of its buckets, so every other add needs
+	// a new table.
+	exec(map[string]interface{}{"cmd": "cf.reserve", "key": "ids", "capacity": 4, "bucket_size": 1, "expansion": 1})
+	n := 10
+	for i := 0; i < n; i++ {
+		if res := exec(map[string]interface{}{"cmd": "cf.add", "key": "ids", "item": "a"}); res["status"] != true {
+			t.Fatalf("got: %v, want: ok", res)
+		}
+	}
+	if res := exec(map[string]interface{}{"cmd": "cf.count", "key": "ids", "item": "a"}); res["value"] != n {
+		t.Errorf("got: %v, want: %v", res["value"], n)
+	}
+	info := exec(map[string]interface{}{"cmd": "cf.info", "key": "ids"})["value"].(map[string]interface{})
+	if info["items"] != n || info["filters"].(int) < n/2 {
+		t.Errorf("got: %v", info)
+	}
+}
@@ -7,11 +7,35 @@ const (
 	ErrorCodeCommandFormatError   = 200
 	ErrorCodeCommandNotFoundError = 300
 	ErrorCodeCommandExecuteError  = 400
+	ErrorCodeMaxClientsError      = 500
 )
 
 var (
-	BucketsLEZeroError   = errors.New("bucket num can't le 0")
-	BucketNotFoundError  = errors.New("bucket not found")
-	ValueNotFoundError   = errors.New("value not found")
-	CommandNotFoundError = errors.New("command not found")
+	BucketsLEZeroError    = errors.New("bucket num can't le 0")
+	BucketNotFoundError   = errors.New("bucket not found")
+	ValueNotFoundError    = errors.New("value not found")
+	CommandNotFoundError  = errors.New("command not found")
+	MaxClientsError       = errors.New("max number of clients reached")
+	ConfigFileNotSetError = errors.New("config file not set")
+	ResizeInProgressError = errors.New("resize in progress")
+	IndexExistsError      = errors.New("index already exists")
+	IndexNotFoundError    = errors.New("index not found")
+	StreamIDError         = errors.New("invalid stream id")
+	StreamIDTooSmallError = errors.New("stream id is not above the last id")
+	GroupExistsError      = errors.New("consumer group already exists")
+	GroupNotFoundError    = errors.New("consumer group not found")
+	WrongTypeError        = errors.New("operation against a key holding the wrong kind of value")
+	InvalidHLLError       = errors.New("key is not a valid hyperloglog value")
+	FilterExistsError     = errors.New("filter already exists")
+	FilterFullError       = errors.New("filter is full")
+	CuckooFullError       = errors.New("cuckoo filter is full")
+	NoScriptError         = errors.New("no script with this sha")
+	NoScriptRunningError  = errors.New("no script is running")
+	ScriptKilledError     = errors.New("script killed")
+	ScriptTimeoutError    = errors.New("script timed out")
+	ScriptKeyError        = errors.New("script accessed a key it did not declare")
+	ScriptCommandError    = errors.New("command not allowed from scripts")
+	CommandExistsError    = errors.New("command already exists")
+	ReadOnlyBucketError   = errors.New("bucket is locked for reading")
+	KeyNotInBucketError   = errors.New("key is not in the locked bucket")
 )
@@ -0,0 +1,382 @@
+package memds
+
+import (
+	"math"
+	"sort"
+)
+
+// Members of geo sets are stored in sorted sets, scored by the 52 bit
+// geohash of their position, so members close to each other have close
+// scores.
+const (
+	geoStep      = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180
+	geoLonMax    = 180
+	earthRadius  = 6372797.560856
+	metersPerDeg = earthRadius * math.Pi / 180
+)
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+// geoEncode returns the geohash of a position at step, in bits per
+// coordinate.
+func geoEncode(lon, lat float64, step uint) uint64 {
+	x := geoCell(lon, geoLonMin, geoLonMax, step)
+	y := geoCell(lat, geoLatMin, geoLatMax, step)
+	return geoInterleave(x, y, step)
+}
+
+func geoCell(v, min, max float64, step uint) uint64 {
+	n := uint64(1) << step
+	c := uint64((v - min) / (max - min) * float64(n))
+	if c >= n {
+		c = n - 1
+	}
+	return c
+}
+
+// geoInterleave interleaves the bits of x and y, x taking the higher bit of
+// each pair.
+func geoInterleave(x, y uint64, step uint) uint64 {
+	var h uint64
+	for i := int(step) - 1; i >= 0; i-- {
+		h = h<<2 | (x>>uint(i)&1)<<1 | y>>uint(i)&1
+	}
+	return h
+}
+
+func geoDeinterleave(h uint64, step uint) (uint64, uint64) {
+	var x, y uint64
+	for i := int(step) - 1; i >= 0; i-- {
+		x = x<<1 | h>>(2*uint(i)+1)&1
+		y = y<<1 | h>>(2*uint(i))&1
+	}
+	return x, y
+}
+
+// geoDecode returns the center of the cell of a geohash at geoStep.
+func geoDecode(h uint64) (float64, float64) {
+	x, y := geoDeinterleave(h, geoStep)
+	n := float64(uint64(1) << geoStep)
+	lon := geoLonMin + (float64(x)+0.5)*(geoLonMax-geoLonMin)/n
+	lat := geoLatMin + (float64(y)+0.5)*(geoLatMax-geoLatMin)/n
+	return lon, lat
+}
+
+// geoDistance returns the distance in meters between two positions with the
+// haversine formula.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	u := math.Sin((lat2 - lat1) * rad / 2)
+	v := math.Sin((lon2 - lon1) * rad / 2)
+	a := u*u + math.Cos(lat1*rad)*math.Cos(lat2*rad)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoStepFor returns the largest step whose cells around lat are at least
+// width by height meters, so the 3 by 3 cells around a position cover a
+// shape of twice that size centered on it.
+func geoStepFor(lat, width, height float64) uint {
+	edge := math.Min(math.Abs(lat)+height/metersPerDeg, 90)
+	cos := math.Cos(edge * math.Pi / 180)
+	for step := uint(geoStep); step > 1; step-- {
+		n := float64(uint64(1) << step)
+		w := (geoLonMax - geoLonMin) / n * metersPerDeg * cos
+		h := (geoLatMax - geoLatMin) / n * metersPerDeg
+		if w >= width && h >= height {
+			return step
+		}
+	}
+	return 1
+}
+
+// geoRanges returns the score ranges, both bounds included, of the 3 by 3
+// cells at step around a position.
+func geoRanges(lon, lat float64, step uint) [][2]float64 {
+	x0 := geoCell(lon, geoLonMin, geoLonMax, step)
+	y0 := geoCell(lat, geoLatMin, geoLatMax, step)
+	n := int64(1) << step
+	shift := 2 * (geoStep - step)
+
+	seen := make(map[uint64]bool)
+	var r [][2]float64
+	for dy := int64(-1); dy <= 1; dy++ {
+		y := int64(y0) + dy
+		if y < 0 || y >= n {
+			continue
+		}
+		for dx := int64(-1); dx <= 1; dx++ {
+			// Longitude wraps around.
+			x := (int64(x0) + dx + n) % n
+			h := geoInterleave(uint64(x), uint64(y), step)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			r = append(r, [2]float64{float64(h << shift), float64((h+1)<<shift - 1)})
+		}
+	}
+	return r
+}
+
+// geoShape is the area a geosearch looks in, a circle of radius meters or
+// a box of width by height meters centered on lon and lat.
+type geoShape struct {
+	lon, lat      float64
+	radius        float64
+	width, height float64
+}
+
+// distance returns the distance of a position from the center of g, and
+// whether the position is inside g.
+func (g geoShape) distance(lon, lat float64) (float64, bool) {
+	d := geoDistance(g.lon, g.lat, lon, lat)
+	if g.radius > 0 {
+		return d, d <= g.radius
+	}
+	dLat := geoDistance(lon, g.lat, lon, lat)
+	dLon := geoDistance(g.lon, lat, lon, lat)
+	return d, dLat <= g.height/2 && dLon <= g.width/2
+}
+
+func (g geoShape) search(z *zset) []geoResult {
+	w, h := g.radius, g.radius
+	if g.radius == 0 {
+		w, h = g.width/2, g.height/2
+	}
+	var r []geoResult
+	for _, rg := range geoRanges(g.lon, g.lat, geoStepFor(g.lat, w, h)) {
+		for _, e := range z.byScore(rg[0], rg[1], 0) {
+			lon, lat := geoDecode(uint64(e.score))
+			if d, ok := g.distance(lon, lat); ok {
+				r = append(r, geoResult{member: e.member, dist: d, lon: lon, lat: lat})
+			}
+		}
+	}
+	return r
+}
+
+type geoResult struct {
+	member   string
+	dist     float64
+	lon, lat float64
+}
+
+// geoPositionArg returns v, a list of longitude and latitude, as a position.
+func (s *Store) geoPositionArg(name string, v interface{}) (float64, float64, map[string]interface{}) {
+	ls, ok := v.([]interface{})
+	if !ok || len(ls) != 2 {
+		return 0, 0, s.responseCmdFormatError("key '" + name + "' not a longitude and latitude list")
+	}
+	lon, ok1 := floatValue(ls[0])
+	lat, ok2 := floatValue(ls[1])
+	if !ok1 || !ok2 || lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, s.responseCmdFormatError("key '" + name + "' not a valid position")
+	}
+	return lon, lat, nil
+}
+
+// geoUnitArg returns the meters in the "unit" of cmd, m if missing.
+func (s *Store) geoUnitArg(cmd map[string]interface{}) (float64, map[string]interface{}) {
+	unit, errRes := s.optionalStringArg(cmd, "unit")
+	if errRes != nil {
+		return 0, errRes
+	}
+	if unit == "" {
+		return 1, nil
+	}
+	m, ok := geoUnits[unit]
+	if !ok {
+		return 0, s.responseCmdFormatError("key 'unit' not m, km, mi or ft")
+	}
+	return m, nil
+}
+
+func (s *Store) execGeo(name string, cmd map[string]interface{}) map[string]interface{} {
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		z, err := b.zset(k, name == "geoadd")
+		if err != nil {
+			return err
+		}
+		if z == nil {
+			z = newZset().(*zset)
+		}
+		res = s.execGeoOn(name, b, k, z, cmd)
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if name == "geoadd" && res["status"] == true {
+		s.waiters.notify(k)
+	}
+	return res
+}
+
+// execGeoOn runs a geo command on the sorted set z at the key k of b. The
+// caller must hold b.mu for writing.
+func (s *Store) execGeoOn(name string, b *Bucket, k string, z *zset, cmd map[string]interface{}) map[string]interface{} {
+	switch name {
+	case "geoadd":
+		members, ok := cmd["members"].(map[string]interface{})
+		if !ok || len(members) == 0 {
+			if len(z.entries) == 0 {
+				b.remove(k)
+			}
+			return s.responseCmdFormatError("key 'members' not type map")
+		}
+		scores := make(map[string]float64, len(members))
+		for m, v := range members {
+			lon, lat, errRes := s.geoPositionArg("members", v)
+			if errRes != nil {
+				if len(z.entries) == 0 {
+					b.remove(k)
+				}
+				return errRes
+			}
+			scores[m] = float64(geoEncode(lon, lat, geoStep))
+		}
+		n := 0
+		for m, score := range scores {
+			if z.add(m, score) {
+				n++
+			}
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "geopos":
+		members, errRes := s.stringsArg(cmd, "members")
+		if errRes != nil {
+			return errRes
+		}
+		r := make([]interface{}, 0, len(members))
+		for _, m := range members {
+			score, ok := z.scores[m]
+			if !ok {
+				r = append(r, nil)
+				continue
+			}
+			lon, lat := geoDecode(uint64(score))
+			r = append(r, []interface{}{lon, lat})
+		}
+		return s.response(map[string]interface{}{"value": r})
+	case "geodist":
+		members, errRes := s.stringsArg(cmd, "members")
+		if errRes != nil {
+			return errRes
+		}
+		if len(members) != 2 {
+			return s.responseCmdFormatError("key 'members' not two members")
+		}
+		unit, errRes := s.geoUnitArg(cmd)
+		if errRes != nil {
+			return errRes
+		}
+		s1, ok1 := z.scores[members[0]]
+		s2, ok2 := z.scores[members[1]]
+		if !ok1 || !ok2 {
+			return s.response(map[string]interface{}{"value": nil})
+		}
+		lon1, lat1 := geoDecode(uint64(s1))
+		lon2, lat2 := geoDecode(uint64(s2))
+		return s.response(map[string]interface{}{"value": geoDistance(lon1, lat1, lon2, lat2) / unit})
+	case "geosearch":
+		return s.execGeoSearch(z, cmd)
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// execGeoSearch returns the members within "radius", or a box of "width"
+// by "height", around "member" or the position "from", nearest first unless
+// "sort" is desc.
+func (s *Store) execGeoSearch(z *zset, cmd map[string]interface{}) map[string]interface{} {
+	unit, errRes := s.geoUnitArg(cmd)
+	if errRes != nil {
+		return errRes
+	}
+
+	var g geoShape
+	if m, ok := cmd["member"]; ok && m != nil {
+		member, errRes := s.stringArg(cmd, "member")
+		if errRes != nil {
+			return errRes
+		}
+		score, ok := z.scores[member]
+		if !ok {
+			return s.responseCmdExecuteError(ValueNotFoundError.Error())
+		}
+		g.lon, g.lat = geoDecode(uint64(score))
+	} else {
+		if g.lon, g.lat, errRes = s.geoPositionArg("from", cmd["from"]); errRes != nil {
+			return errRes
+		}
+	}
+
+	dims := []struct {
+		name string
+		p    *float64
+	}{{"radius", &g.radius}, {"width", &g.width}, {"height", &g.height}}
+	for _, d := range dims {
+		name, p := d.name, d.p
+		if v, ok := cmd[name]; ok {
+			f, ok := floatValue(v)
+			if !ok || f <= 0 {
+				return s.responseCmdFormatError("key '" + name + "' not a number above 0")
+			}
+			*p = f * unit
+		}
+	}
+	switch {
+	case g.radius > 0 && g.width == 0 && g.height == 0:
+	case g.radius == 0 && g.width > 0 && g.height > 0:
+	default:
+		return s.responseCmdFormatError("give either 'radius' or 'width' and 'height'")
+	}
+
+	order, errRes := s.optionalStringArg(cmd, "sort")
+	if errRes != nil {
+		return errRes
+	}
+	if order != "" && order != "asc" && order != "desc" {
+		return s.responseCmdFormatError("key 'sort' not asc or desc")
+	}
+	count, errRes := s.optionalIntArg(cmd, "count", 0)
+	if errRes != nil {
+		return errRes
+	}
+
+	found := g.search(z)
+	sort.Slice(found, func(i, j int) bool {
+		if order == "desc" {
+			return found[i].dist > found[j].dist
+		}
+		return found[i].dist < found[j].dist
+	})
+	if count > 0 && int(count) < len(found) {
+		found = found[:count]
+	}
+
+	r := make([]interface{}, 0, len(found))
+	for _, e := range found {
+		r = append(r, map[string]interface{}{
+			"member": e.member,
+			"dist":   e.dist / unit,
+			"lon":    e.lon,
+			"lat":    e.lat,
+		})
+	}
+	return s.response(map[string]interface{}{"value": r})
+}
@@ -0,0 +1,137 @@
+package memds
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGeo(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	members := map[string]interface{}{
+		"Palermo": []interface{}{13.361389, 38.115556},
+		"Catania": []interface{}{15.087269, 37.502669},
+	}
+	res := exec(map[string]interface{}{"cmd": "geoadd", "key": "sicily", "members": members})
+	if res["value"] != 2 {
+		t.Fatalf("got: %v, want: 2", res)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "geodist", "key": "sicily", "members": []interface{}{"Palermo", "Catania"}, "unit": "km"})
+	if d, _ := res["value"].(float64); math.Abs(d-166.2742) > 0.01 {
+		t.Errorf("got: %v, want: 166.2742", res)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "geopos", "key": "sicily", "members": []interface{}{"Palermo", "missing"}})
+	pos := res["value"].([]interface{})
+	if p := pos[0].([]interface{}); math.Abs(p[0].(float64)-13.361389) > 1e-5 || math.Abs(p[1].(float64)-38.115556) > 1e-5 {
+		t.Errorf("got: %v, want: [13.361389 38.115556]", p)
+	}
+	if pos[1] != nil {
+		t.Errorf("got: %v, want: nil", pos[1])
+	}
+
+	testCase := []struct {
+		Cmd     map[string]interface{}
+		Members []string
+	}{
+		{
+			Cmd:     map[string]interface{}{"cmd": "geosearch", "key": "sicily", "from": []interface{}{15, 37}, "radius": 200, "unit": "km"},
+			Members: []string{"Catania", "Palermo"},
+		},
+		{
+			Cmd:     map[string]interface{}{"cmd": "geosearch", "key": "sicily", "from": []interface{}{15, 37}, "radius": 100, "unit": "km"},
+			Members: []string{"Catania"},
+		},
+		{
+			Cmd:     map[string]interface{}{"cmd": "geosearch", "key": "sicily", "member": "Palermo", "radius": 200, "unit": "km", "sort": "desc"},
+			Members: []string{"Catania", "Palermo"},
+		},
+		{
+			Cmd:     map[string]interface{}{"cmd": "geosearch", "key": "sicily", "from": []interface{}{15, 37}, "width": 400, "height": 120, "unit": "km", "count": 1},
+			Members: []string{"Catania"},
+		},
+		{
+			Cmd:     map[string]interface{}{"cmd": "geosearch", "key": "sicily", "from": []interface{}{15, 37}, "width": 100, "height": 400, "unit": "km"},
+			Members: []string{"Catania"},
+		},
+	}
+	for _, tc := range testCase {
+		if got := geoMembers(exec(tc.Cmd)); !reflect.DeepEqual(got, tc.Members) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, got, tc.Members)
+		}
+	}
+
+	res = exec(map[string]interface{}{"cmd": "geoadd", "key": "bad", "members": map[string]interface{}{"a": []interface{}{0, 89}}})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "geosearch", "key": "sicily", "from": []interface{}{15, 37}, "radius": 1, "width": 1})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+}
+
+// TestGeoSearchScan checks geosearch against a scan of every member, also
+// around the poles and where longitude wraps around.
+func TestGeoSearchScan(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	centers := [][2]float64{{139.767, 35.681}, {179.9, 0}, {-179.9, 80}, {0, -84}}
+	type point struct {
+		name     string
+		lon, lat float64
+	}
+	var points []point
+	members := make(map[string]interface{})
+	for i := 0; i < 2000; i++ {
+		c := centers[i%len(centers)]
+		lon := c[0] + rnd.Float64()*4 - 2
+		lat := math.Max(math.Min(c[1]+rnd.Float64()*2-1, geoLatMax), geoLatMin)
+		lon = math.Mod(lon+540, 360) - 180
+		name := fmt.Sprintf("p%d", i)
+		members[name] = []interface{}{lon, lat}
+		lon, lat = geoDecode(geoEncode(lon, lat, geoStep))
+		points = append(points, point{name: name, lon: lon, lat: lat})
+	}
+	exec(map[string]interface{}{"cmd": "geoadd", "key": "points", "members": members})
+
+	for _, c := range centers {
+		for _, radius := range []float64{1000, 20000, 100000} {
+			g := geoShape{lon: c[0], lat: c[1], radius: radius}
+			var want []string
+			for _, p := range points {
+				if _, ok := g.distance(p.lon, p.lat); ok {
+					want = append(want, p.name)
+				}
+			}
+			res := exec(map[string]interface{}{"cmd": "geosearch", "key": "points", "from": []interface{}{c[0], c[1]}, "radius": radius})
+			got := geoMembers(res)
+			sort.Strings(got)
+			sort.Strings(want)
+			if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+				t.Errorf("%v %v got: %d members, want: %d", c, radius, len(got), len(want))
+			}
+		}
+	}
+}
+
+func geoMembers(res map[string]interface{}) []string {
+	ls, _ := res["value"].([]interface{})
+	r := make([]string, 0, len(ls))
+	for _, e := range ls {
+		r = append(r, e.(map[string]interface{})["member"].(string))
+	}
+	return r
+}
@@ -2,15 +2,31 @@ package memds
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
 
 var (
-	mh      codec.MsgpackHandle
-	buckets Buckets
+	// Version is reported to clients, cmd/memds sets it at startup.
+	Version = "0.1.0"
+
+	mh           codec.MsgpackHandle
+	defaultStore *Store
 )
 
 func init() {
 	mh.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	defaultStore = &Store{
+		config:  new(Config),
+		mh:      &mh,
+		metrics: newMetrics(),
+		started: time.Now(),
+	}
+}
+
+func newMsgpackHandle() *codec.MsgpackHandle {
+	h := new(codec.MsgpackHandle)
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
 }
@@ -0,0 +1,167 @@
+package memds
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+const defaultHash = "crc32"
+
+// Hash maps keys to buckets.
+type Hash interface {
+	Sum32(k []byte) uint32
+}
+
+var hashes = map[string]Hash{
+	"crc32":   crc32Hash{},
+	"xxhash":  xxHash{},
+	"fnv-1a":  fnvHash{},
+	"murmur3": murmur3Hash{},
+}
+
+// newHash returns the hash named by Config.Hash. An empty name means crc32.
+func newHash(name string) (Hash, error) {
+	if name == "" {
+		name = defaultHash
+	}
+	h, ok := hashes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash: %s", name)
+	}
+	return h, nil
+}
+
+// hashTag returns the part of k hashed to pick its bucket. If k holds a
+// non-empty {tag}, only the tag is hashed so keys sharing it land in the
+// same bucket.
+func hashTag(k string) string {
+	i := strings.IndexByte(k, '{')
+	if i < 0 {
+		return k
+	}
+	j := strings.IndexByte(k[i+1:], '}')
+	if j <= 0 {
+		return k
+	}
+	return k[i+1 : i+1+j]
+}
+
+type crc32Hash struct{}
+
+func (crc32Hash) Sum32(k []byte) uint32 {
+	return crc32.ChecksumIEEE(k)
+}
+
+type fnvHash struct{}
+
+func (fnvHash) Sum32(k []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(k)
+	return h.Sum32()
+}
+
+// xxHash is the 32 bit xxHash with seed 0.
+type xxHash struct{}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+func xxRound(acc, v uint32) uint32 {
+	acc += v * xxPrime2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * xxPrime1
+}
+
+func (xxHash) Sum32(k []byte) uint32 {
+	n := len(k)
+	var h uint32
+	if n >= 16 {
+		p1, p2 := xxPrime1, xxPrime2
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint32(0)
+		v4 := -p1
+		for ; len(k) >= 16; k = k[16:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint32(k[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint32(k[4:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint32(k[8:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint32(k[12:]))
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = xxPrime5
+	}
+	h += uint32(n)
+
+	for ; len(k) >= 4; k = k[4:] {
+		h += binary.LittleEndian.Uint32(k) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for _, c := range k {
+		h += uint32(c) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+// murmur3Hash is the 32 bit MurmurHash3 with seed 0.
+type murmur3Hash struct{}
+
+const (
+	murmurC1 uint32 = 0xcc9e2d51
+	murmurC2 uint32 = 0x1b873593
+)
+
+func (murmur3Hash) Sum32(k []byte) uint32 {
+	n := len(k)
+	var h uint32
+	for ; len(k) >= 4; k = k[4:] {
+		c := binary.LittleEndian.Uint32(k)
+		c *= murmurC1
+		c = bits.RotateLeft32(c, 15)
+		c *= murmurC2
+		h ^= c
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var c uint32
+	switch len(k) {
+	case 3:
+		c ^= uint32(k[2]) << 16
+		fallthrough
+	case 2:
+		c ^= uint32(k[1]) << 8
+		fallthrough
+	case 1:
+		c ^= uint32(k[0])
+		c *= murmurC1
+		c = bits.RotateLeft32(c, 15)
+		c *= murmurC2
+		h ^= c
+	}
+
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,98 @@
+package memds
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	testCase := []struct {
+		Hash string
+		In   string
+		Out  uint32
+	}{
+		{Hash: "crc32", In: "hello", Out: 0x3610a686},
+		{Hash: "fnv-1a", In: "hello", Out: 0x4f9f2cab},
+		{Hash: "xxhash", In: "", Out: 0x02cc5d05},
+		{Hash: "xxhash", In: "abc", Out: 0x32d153ff},
+		{Hash: "xxhash", In: "Nobody inspects the spammish repetition", Out: 0xe2293b2f},
+		{Hash: "murmur3", In: "", Out: 0},
+		{Hash: "murmur3", In: "hello", Out: 0x248bfa47},
+		{Hash: "murmur3", In: "The quick brown fox jumps over the lazy dog", Out: 0x2e4ff723},
+	}
+	for _, tc := range testCase {
+		h, err := newHash(tc.Hash)
+		if err != nil {
+			t.Fatalf("got: %v, want: nil", err)
+		}
+		if n := h.Sum32([]byte(tc.In)); n != tc.Out {
+			t.Errorf("%s %q got: %x, want: %x", tc.Hash, tc.In, n, tc.Out)
+		}
+	}
+
+	if _, err := newHash("md5"); err == nil {
+		t.Error("should be error when unknown hash")
+	}
+	if _, err := NewStore(&Config{BucketNum: 2, Hash: "md5"}); err == nil {
+		t.Error("should be error when unknown hash")
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	testCase := []struct {
+		In  string
+		Out string
+	}{
+		{In: "user:42", Out: "user:42"},
+		{In: "{user:42}:profile", Out: "user:42"},
+		{In: "profile:{user:42}", Out: "user:42"},
+		{In: "{}:profile", Out: "{}:profile"},
+		{In: "{user:42:profile", Out: "{user:42:profile"},
+		{In: "{a}{b}", Out: "a"},
+	}
+	for _, tc := range testCase {
+		if got := hashTag(tc.In); got != tc.Out {
+			t.Errorf("got: %v, want: %v", got, tc.Out)
+		}
+	}
+
+	for name := range hashes {
+		s, _ := NewStore(&Config{BucketNum: 16, Hash: name})
+		if s.bucket("{user:42}:profile") != s.bucket("{user:42}:cart") {
+			t.Errorf("%s: keys with the same tag should share a bucket", name)
+		}
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	keys := map[string][]byte{
+		"short": []byte("user:42"),
+		"long":  []byte(strings.Repeat("session:0123456789abcdef:", 10)),
+	}
+	for _, name := range []string{"crc32", "xxhash", "fnv-1a", "murmur3"} {
+		h := hashes[name]
+		for _, size := range []string{"short", "long"} {
+			k := keys[size]
+			b.Run(name+"/"+size, func(b *testing.B) {
+				b.SetBytes(int64(len(k)))
+				for i := 0; i < b.N; i++ {
+					h.Sum32(k)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkHashSet(b *testing.B) {
+	for _, name := range []string{"crc32", "xxhash", "fnv-1a", "murmur3"} {
+		b.Run(name, func(b *testing.B) {
+			s, _ := NewStore(&Config{BucketNum: 16, Hash: name})
+			v := []byte("value")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Set("key:"+strconv.Itoa(i), v)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package memds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are string values holding a header and the registers, either
+// sparse, as pairs of a 2 byte register index and its 1 byte value for the
+// registers that are not 0, or dense, as 6 bits per register.
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllDenseSize = hllRegisters * hllBits / 8
+	// hllSparseMax is the size sparse registers are converted to dense at.
+	hllSparseMax = 3000
+	hllHeaderLen = 8
+	hllSparse    = 0
+	hllDense     = 1
+)
+
+var hllMagic = []byte("HYLL")
+
+// hll holds the registers of a HyperLogLog while it is used.
+type hll [hllRegisters]uint8
+
+// decodeHLL returns the registers of the value p, all 0 if p is nil.
+func decodeHLL(p []byte) (*hll, error) {
+	var h hll
+	if p == nil {
+		return &h, nil
+	}
+	if len(p) < hllHeaderLen || !bytes.Equal(p[:len(hllMagic)], hllMagic) {
+		return nil, InvalidHLLError
+	}
+	regs := p[hllHeaderLen:]
+	switch p[len(hllMagic)] {
+	case hllSparse:
+		if len(regs)%3 != 0 {
+			return nil, InvalidHLLError
+		}
+		for i := 0; i < len(regs); i += 3 {
+			n := binary.BigEndian.Uint16(regs[i:])
+			if n >= hllRegisters || regs[i+2] > hllQ+1 {
+				return nil, InvalidHLLError
+			}
+			h[n] = regs[i+2]
+		}
+	case hllDense:
+		if len(regs) != hllDenseSize {
+			return nil, InvalidHLLError
+		}
+		for i := range h {
+			h[i] = denseRegister(regs, i)
+		}
+	default:
+		return nil, InvalidHLLError
+	}
+	return &h, nil
+}
+
+func denseRegister(regs []byte, i int) uint8 {
+	bit := i * hllBits
+	v := uint16(regs[bit/8])
+	if bit/8+1 < len(regs) {
+		v |= uint16(regs[bit/8+1]) << 8
+	}
+	return uint8(v>>uint(bit%8)) & (1<<hllBits - 1)
+}
+
+func setDenseRegister(regs []byte, i int, v uint8) {
+	bit := i * hllBits
+	w := uint16(regs[bit/8])
+	if bit/8+1 < len(regs) {
+		w |= uint16(regs[bit/8+1]) << 8
+	}
+	w &^= (1<<hllBits - 1) << uint(bit%8)
+	w |= uint16(v) << uint(bit%8)
+	regs[bit/8] = byte(w)
+	if bit/8+1 < len(regs) {
+		regs[bit/8+1] = byte(w >> 8)
+	}
+}
+
+// encode returns h encoded as a msgpack str, sparse while that stays below
+// hllSparseMax bytes.
+func (h *hll) encode() []byte {
+	n := 0
+	for _, r := range h {
+		if r != 0 {
+			n++
+		}
+	}
+	if n*3 > hllSparseMax {
+		v, p := newMsgpackStr(hllHeaderLen + hllDenseSize)
+		copy(p, hllMagic)
+		p[len(hllMagic)] = hllDense
+		regs := p[hllHeaderLen:]
+		for i, r := range h {
+			setDenseRegister(regs, i, r)
+		}
+		return v
+	}
+
+	v, p := newMsgpackStr(hllHeaderLen + n*3)
+	copy(p, hllMagic)
+	p[len(hllMagic)] = hllSparse
+	regs := p[hllHeaderLen:]
+	for i, r := range h {
+		if r != 0 {
+			binary.BigEndian.PutUint16(regs, uint16(i))
+			regs[2] = r
+			regs = regs[3:]
+		}
+	}
+	return v
+}
+
+// add adds e and reports whether a register changed.
+func (h *hll) add(e []byte) bool {
+	x := murmur64a(e, 0xadc83b19)
+	i := x & (hllRegisters - 1)
+	r := uint8(bits.TrailingZeros64(x>>hllP|1<<hllQ)) + 1
+	if r <= h[i] {
+		return false
+	}
+	h[i] = r
+	return true
+}
+
+func (h *hll) merge(o *hll) {
+	for i, r := range o {
+		if r > h[i] {
+			h[i] = r
+		}
+	}
+}
+
+// count estimates the number of distinct elements added with the estimator
+// of Otmar Ertl, "New cardinality estimation algorithms for HyperLogLog
+// sketches".
+func (h *hll) count() int64 {
+	var histo [hllQ + 2]int
+	for _, r := range h {
+		histo[r]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return int64(math.Round(0.5 / math.Ln2 * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// murmur64a is the 64 bit MurmurHash64A.
+func murmur64a(k []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+	h := seed ^ uint64(len(k))*m
+	for ; len(k) >= 8; k = k[8:] {
+		c := binary.LittleEndian.Uint64(k)
+		c *= m
+		c ^= c >> r
+		c *= m
+		h ^= c
+		h *= m
+	}
+	if len(k) > 0 {
+		for i := len(k) - 1; i >= 0; i-- {
+			h ^= uint64(k[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hll returns the registers of the HyperLogLog at k. The caller must hold
+// b.mu.
+func (b *Bucket) hll(k string) (*hll, bool, error) {
+	p, err := b.bitmap(k)
+	if err != nil {
+		return nil, false, err
+	}
+	h, err := decodeHLL(p)
+	return h, p != nil, err
+}
+
+func (s *Store) execHLL(name string, cmd map[string]interface{}) map[string]interface{} {
+	switch name {
+	case "pfadd":
+		k, errRes := s.stringArg(cmd, "key")
+		if errRes != nil {
+			return errRes
+		}
+		var es []string
+		if _, ok := cmd["elements"]; ok {
+			if es, errRes = s.stringsArg(cmd, "elements"); errRes != nil {
+				return errRes
+			}
+		}
+		changed := false
+		err := s.update(k, func(b *Bucket) error {
+			h, ok, err := b.hll(k)
+			if err != nil {
+				return err
+			}
+			changed = !ok
+			for _, e := range es {
+				if h.add([]byte(e)) {
+					changed = true
+				}
+			}
+			if changed {
+				b.putBitmap(k, h.encode())
+			}
+			return nil
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		n := 0
+		if changed {
+			n = 1
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "pfcount":
+		keys, errRes := s.stringsArg(cmd, "keys")
+		if errRes != nil {
+			return errRes
+		}
+		h, err := s.mergeHLL(keys)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.response(map[string]interface{}{"value": h.count()})
+	case "pfmerge":
+		dest, errRes := s.stringArg(cmd, "dest")
+		if errRes != nil {
+			return errRes
+		}
+		keys, errRes := s.stringsArg(cmd, "keys")
+		if errRes != nil {
+			return errRes
+		}
+		h, err := s.mergeHLL(keys)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		err = s.update(dest, func(b *Bucket) error {
+			d, _, err := b.hll(dest)
+			if err != nil {
+				return err
+			}
+			d.merge(h)
+			b.putBitmap(dest, d.encode())
+			return nil
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.responseOK()
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// mergeHLL returns the union of the HyperLogLogs at keys.
+func (s *Store) mergeHLL(keys []string) (*hll, error) {
+	var r hll
+	for _, k := range keys {
+		err := s.view(k, func(b *Bucket) error {
+			h, _, err := b.hll(k)
+			if err == nil {
+				r.merge(h)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
@@ -0,0 +1,86 @@
+package memds
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHLL(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	res := exec(map[string]interface{}{"cmd": "pfadd", "key": "a", "elements": []interface{}{"x", "y", "z", "x"}})
+	if res["value"] != 1 {
+		t.Errorf("got: %v, want: 1", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "pfadd", "key": "a", "elements": "y"})
+	if res["value"] != 0 {
+		t.Errorf("got: %v, want: 0", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "pfcount", "keys": "a"})
+	if res["value"] != int64(3) {
+		t.Errorf("got: %v, want: 3", res)
+	}
+
+	testCase := []struct {
+		N        int
+		Encoding byte
+	}{
+		{N: 100, Encoding: hllSparse},
+		{N: 10000, Encoding: hllDense},
+		{N: 200000, Encoding: hllDense},
+	}
+	for _, tc := range testCase {
+		k := fmt.Sprintf("n%d", tc.N)
+		es := make([]interface{}, 0, tc.N)
+		for i := 0; i < tc.N; i++ {
+			es = append(es, fmt.Sprintf("visitor:%d", i))
+		}
+		exec(map[string]interface{}{"cmd": "pfadd", "key": k, "elements": es})
+
+		n := exec(map[string]interface{}{"cmd": "pfcount", "keys": k})["value"].(int64)
+		if e := math.Abs(float64(n)-float64(tc.N)) / float64(tc.N); e > 0.02 {
+			t.Errorf("%d got: %v, want: within 2%%", tc.N, n)
+		}
+		v, _ := s.Get(k)
+		if p := v.([]byte); p[len(hllMagic)] != tc.Encoding {
+			t.Errorf("%d got: encoding %d, want: %d", tc.N, p[len(hllMagic)], tc.Encoding)
+		}
+	}
+
+	// n100 is a subset of n10000.
+	res = exec(map[string]interface{}{"cmd": "pfcount", "keys": []interface{}{"n100", "n10000", "missing"}})
+	if n := res["value"].(int64); math.Abs(float64(n)-10000) > 200 {
+		t.Errorf("got: %v, want: about 10000", n)
+	}
+	exec(map[string]interface{}{"cmd": "pfmerge", "dest": "a", "keys": []interface{}{"n100", "n200000"}})
+	res = exec(map[string]interface{}{"cmd": "pfcount", "keys": "a"})
+	if n := res["value"].(int64); math.Abs(float64(n)-200003) > 4000 {
+		t.Errorf("got: %v, want: about 200003", n)
+	}
+
+	s.Set("str", []byte("not a hll"))
+	res = exec(map[string]interface{}{"cmd": "pfadd", "key": "str", "elements": "x"})
+	if res["msg"] != InvalidHLLError.Error() {
+		t.Errorf("got: %v, want: %v", res, InvalidHLLError)
+	}
+}
+
+func TestHLLDenseRegisters(t *testing.T) {
+	var h hll
+	for i := range h {
+		h[i] = uint8(i % (hllQ + 2))
+	}
+	h[0] = hllQ + 1
+	p, ok := msgpackBytes(h.encode())
+	if !ok || len(p) != hllHeaderLen+hllDenseSize {
+		t.Fatalf("got: %d bytes, want: %d", len(p), hllHeaderLen+hllDenseSize)
+	}
+	d, err := decodeHLL(p)
+	if err != nil || *d != h {
+		t.Errorf("got: %v, want: registers unchanged", err)
+	}
+}
@@ -0,0 +1,198 @@
+package memds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const httpKeysPrefix = "/keys/"
+
+func (s *Server) startHTTP() error {
+	if s.config.HTTPPort == 0 {
+		return nil
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.HTTPPort))
+	if err != nil {
+		return err
+	}
+	s.httpListener = l
+
+	go func() {
+		err := http.Serve(l, newHTTPHandler(s.store))
+		if err != nil && !s.isDraining() {
+			Error(fmt.Sprintf("http serve error: %v", err))
+		}
+	}()
+	return nil
+}
+
+func newHTTPHandler(s *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpKeysPrefix, func(w http.ResponseWriter, r *http.Request) {
+		handleHTTPKey(s, w, r)
+	})
+	mux.HandleFunc("/cmd", func(w http.ResponseWriter, r *http.Request) {
+		handleHTTPCmd(s, w, r)
+	})
+	return mux
+}
+
+func handleHTTPKey(s *Store, w http.ResponseWriter, r *http.Request) {
+	k := strings.TrimPrefix(r.URL.Path, httpKeysPrefix)
+	if k == "" {
+		writeHTTPResponse(w, s.responseCmdFormatError("key is empty"))
+		return
+	}
+
+	cmd := map[string]interface{}{"key": k}
+	switch r.Method {
+	case "GET":
+		cmd["cmd"] = "get"
+		res := s.execute(cmd, &client{addr: r.RemoteAddr})
+		if res["status"] == true && res["value"] == nil {
+			writeHTTPJSON(w, http.StatusNotFound, s.errorResponse(map[string]interface{}{"msg": ValueNotFoundError.Error()}))
+			return
+		}
+		writeHTTPResponse(w, res)
+		return
+	case "PUT":
+		v, err := decodeJSON(r)
+		if err != nil {
+			writeHTTPResponse(w, s.responseCmdDecodeError(err.Error()))
+			return
+		}
+		cmd["cmd"] = "set"
+		cmd["value"] = v
+	case "DELETE":
+		cmd["cmd"] = "del"
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeHTTPJSON(w, http.StatusMethodNotAllowed, s.errorResponse(map[string]interface{}{"msg": "method not allowed"}))
+		return
+	}
+	writeHTTPResponse(w, s.execute(cmd, &client{addr: r.RemoteAddr}))
+}
+
+func handleHTTPCmd(s *Store, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeHTTPJSON(w, http.StatusMethodNotAllowed, s.errorResponse(map[string]interface{}{"msg": "method not allowed"}))
+		return
+	}
+
+	v, err := decodeJSON(r)
+	if err != nil {
+		writeHTTPResponse(w, s.responseCmdDecodeError(err.Error()))
+		return
+	}
+	cmd, ok := v.(map[string]interface{})
+	if !ok {
+		writeHTTPResponse(w, s.responseCmdFormatError("cmd not type map"))
+		return
+	}
+	// A blocking command gives up once the request is cancelled.
+	writeHTTPResponse(w, s.execute(cmd, &client{addr: r.RemoteAddr, done: r.Context().Done()}))
+}
+
+func decodeJSON(r *http.Request) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSON(v), nil
+}
+
+// httpStatus maps the ErrorCode* of a command response to a http status.
+func httpStatus(m map[string]interface{}) int {
+	if ok, _ := m["status"].(bool); ok {
+		return http.StatusOK
+	}
+	switch m["code"] {
+	case ErrorCodeCommandDecodeError, ErrorCodeCommandFormatError:
+		return http.StatusBadRequest
+	case ErrorCodeCommandNotFoundError:
+		return http.StatusNotFound
+	case ErrorCodeMaxClientsError:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHTTPResponse(w http.ResponseWriter, m map[string]interface{}) {
+	writeHTTPJSON(w, httpStatus(m), m)
+}
+
+func writeHTTPJSON(w http.ResponseWriter, code int, m map[string]interface{}) {
+	b, err := json.Marshal(toJSON(m))
+	if err != nil {
+		Error(fmt.Sprintf("http response encode error: %v", err))
+		code = http.StatusInternalServerError
+		b = []byte(`{"status":false}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// toJSON converts msgpack decoded values into values encoding/json can
+// marshal, turning raw bytes into strings.
+func toJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []uint8:
+		return Uint8ArrayToString(t)
+	case rawValue:
+		r, _ := t.decode(&mh)
+		return toJSON(r)
+	case []interface{}:
+		r := make([]interface{}, 0, len(t))
+		for _, e := range t {
+			r = append(r, toJSON(e))
+		}
+		return r
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			r[k] = toJSON(e)
+		}
+		return r
+	case map[interface{}]interface{}:
+		r := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			r[fmt.Sprintf("%v", toJSON(k))] = toJSON(e)
+		}
+		return r
+	default:
+		return v
+	}
+}
+
+// fromJSON converts json.Number values into int64 or float64 so they are
+// stored as msgpack numbers.
+func fromJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i, e := range t {
+			t[i] = fromJSON(e)
+		}
+		return t
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = fromJSON(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,145 @@
+package memds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	h := newHTTPHandler(st)
+
+	testCase := []struct {
+		Method string
+		Path   string
+		Body   string
+		Code   int
+		Value  interface{}
+	}{
+		{
+			Method: "GET",
+			Path:   "/keys/key",
+			Code:   http.StatusNotFound,
+		},
+		{
+			Method: "PUT",
+			Path:   "/keys/key",
+			Body:   `"value"`,
+			Code:   http.StatusOK,
+		},
+		{
+			Method: "GET",
+			Path:   "/keys/key",
+			Code:   http.StatusOK,
+			Value:  "value",
+		},
+		{
+			Method: "POST",
+			Path:   "/cmd",
+			Body:   `{"cmd":"get","key":"key"}`,
+			Code:   http.StatusOK,
+			Value:  "value",
+		},
+		{
+			Method: "POST",
+			Path:   "/cmd",
+			Body:   `{"cmd":"get"}`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Method: "POST",
+			Path:   "/cmd",
+			Body:   `{"cmd":"unknown"}`,
+			Code:   http.StatusNotFound,
+		},
+		{
+			Method: "POST",
+			Path:   "/cmd",
+			Body:   `{`,
+			Code:   http.StatusBadRequest,
+		},
+		{
+			Method: "DELETE",
+			Path:   "/keys/key",
+			Code:   http.StatusOK,
+		},
+		{
+			Method: "GET",
+			Path:   "/keys/key",
+			Code:   http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCase {
+		req := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(tc.Body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.Code {
+			t.Errorf("%s %s got: %v, want: %v", tc.Method, tc.Path, w.Code, tc.Code)
+		}
+		if tc.Value == nil {
+			continue
+		}
+		res := make(map[string]interface{})
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("response decode error: %v", err)
+		}
+		if res["value"] != tc.Value {
+			t.Errorf("%s %s got: %v, want: %v", tc.Method, tc.Path, res["value"], tc.Value)
+		}
+	}
+
+	// Reads go through execute like the other methods.
+	var b strings.Builder
+	st.WriteMetrics(&b)
+	if !strings.Contains(b.String(), `memds_commands_total{cmd="get",code="ok"}`) {
+		t.Errorf("get not found in:\n%s", b.String())
+	}
+}
+
+func TestHTTPHandlerRawValues(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2, RawValues: true})
+	h := newHTTPHandler(st)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/keys/key", strings.NewReader(`"value"`)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/keys/key", nil))
+	res := make(map[string]interface{})
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("response decode error: %v", err)
+	}
+	if w.Code != http.StatusOK || res["value"] != "value" {
+		t.Errorf("got: %v %v, want: value", w.Code, res)
+	}
+}
+
+func TestHTTPHandlerBlockingCancel(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	h := newHTTPHandler(st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/cmd", strings.NewReader(`{"cmd": "blpop", "keys": "a", "timeout": 0}`)).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	for !waiting(st, "a", 1) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked pop not cancelled")
+	}
+	if waiting(st, "a", 1) {
+		t.Error("got: waiting, want: waiter removed")
+	}
+}
@@ -0,0 +1,428 @@
+package memds
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ugorji/go/codec"
+)
+
+// indexValue is an indexed field value. Numbers sort before strings.
+type indexValue struct {
+	str bool
+	num float64
+	s   string
+}
+
+// newIndexValue returns v as an indexValue. NaN isn't indexed since it
+// doesn't order against other numbers.
+func newIndexValue(v interface{}) (indexValue, bool) {
+	switch t := v.(type) {
+	case int64:
+		return indexValue{num: float64(t)}, true
+	case uint64:
+		return indexValue{num: float64(t)}, true
+	case int:
+		return indexValue{num: float64(t)}, true
+	case float64:
+		return indexValue{num: t}, !math.IsNaN(t)
+	case float32:
+		return indexValue{num: float64(t)}, !math.IsNaN(float64(t))
+	case string:
+		return indexValue{str: true, s: t}, true
+	case []uint8:
+		return indexValue{str: true, s: Uint8ArrayToString(t)}, true
+	default:
+		return indexValue{}, false
+	}
+}
+
+func (v indexValue) less(o indexValue) bool {
+	if v.str != o.str {
+		return !v.str
+	}
+	if v.str {
+		return v.s < o.s
+	}
+	return v.num < o.num
+}
+
+func (v indexValue) value() interface{} {
+	if v.str {
+		return v.s
+	}
+	return v.num
+}
+
+type indexEntry struct {
+	value indexValue
+	key   string
+}
+
+func (e indexEntry) less(o indexEntry) bool {
+	if e.value != o.value {
+		return e.value.less(o.value)
+	}
+	return e.key < o.key
+}
+
+// index maps the field of msgpack map values of keys matching pattern back to
+// their keys. field may name a nested field with dots, like address.city.
+type index struct {
+	name    string
+	pattern string
+	field   string
+
+	mu sync.RWMutex
+	// keys holds the indexed value of each key, entries the same pairs
+	// ordered by value for range queries.
+	keys    map[string]indexValue
+	entries []indexEntry
+}
+
+func newIndex(name, pattern, field string) *index {
+	i := index{
+		name:    name,
+		pattern: pattern,
+		field:   field,
+		keys:    make(map[string]indexValue),
+	}
+	return &i
+}
+
+func (i *index) match(k string) bool {
+	ok, _ := path.Match(i.pattern, k)
+	return ok
+}
+
+// fieldValue returns the indexed field of a decoded value.
+func (i *index) fieldValue(v interface{}) (indexValue, bool) {
+	for _, name := range strings.Split(i.field, ".") {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			v = m[name]
+		case map[interface{}]interface{}:
+			v = m[name]
+		default:
+			return indexValue{}, false
+		}
+	}
+	return newIndexValue(v)
+}
+
+// set indexes k under v, or drops k from the index if ok is false.
+func (i *index) set(k string, v indexValue, ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if old, found := i.keys[k]; found {
+		if ok && old == v {
+			return
+		}
+		e := indexEntry{value: old, key: k}
+		n := sort.Search(len(i.entries), func(j int) bool { return !i.entries[j].less(e) })
+		for n < len(i.entries) && i.entries[n].key != k {
+			n++
+		}
+		if n < len(i.entries) {
+			i.entries = append(i.entries[:n], i.entries[n+1:]...)
+		}
+		delete(i.keys, k)
+	}
+	if !ok {
+		return
+	}
+	e := indexEntry{value: v, key: k}
+	n := sort.Search(len(i.entries), func(j int) bool { return !i.entries[j].less(e) })
+	i.entries = append(i.entries, indexEntry{})
+	copy(i.entries[n+1:], i.entries[n:])
+	i.entries[n] = e
+	i.keys[k] = v
+}
+
+// query returns the keys whose value is between min and max, both
+// inclusive. A nil bound is open. count limits the keys returned if it is
+// positive.
+func (i *index) query(min, max *indexValue, count int) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	n := 0
+	if min != nil {
+		n = sort.Search(len(i.entries), func(j int) bool { return !i.entries[j].value.less(*min) })
+	}
+	var keys []string
+	for ; n < len(i.entries); n++ {
+		e := i.entries[n]
+		if max != nil && max.less(e.value) {
+			break
+		}
+		if count > 0 && len(keys) == count {
+			break
+		}
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+func (i *index) len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.keys)
+}
+
+// indexes is the registry of the secondary indexes of a Store. Buckets call
+// update and remove while holding their lock, so every change of a key
+// reaches its indexes in order.
+type indexes struct {
+	// n is the number of indexes, read with sync/atomic so buckets skip
+	// the registry when there is none.
+	n  int32
+	mu sync.RWMutex
+	m  map[string]*index
+}
+
+func (is *indexes) add(i *index) bool {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if _, ok := is.m[i.name]; ok {
+		return false
+	}
+	if is.m == nil {
+		is.m = make(map[string]*index)
+	}
+	is.m[i.name] = i
+	atomic.StoreInt32(&is.n, int32(len(is.m)))
+	return true
+}
+
+func (is *indexes) drop(name string) bool {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if _, ok := is.m[name]; !ok {
+		return false
+	}
+	delete(is.m, name)
+	atomic.StoreInt32(&is.n, int32(len(is.m)))
+	return true
+}
+
+func (is *indexes) get(name string) *index {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+
+	return is.m[name]
+}
+
+// list returns the indexes ordered by name.
+func (is *indexes) list() []*index {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+
+	r := make([]*index, 0, len(is.m))
+	for _, i := range is.m {
+		r = append(r, i)
+	}
+	sort.Slice(r, func(a, b int) bool {
+		return r[a].name < r[b].name
+	})
+	return r
+}
+
+func (is *indexes) active() bool {
+	return is != nil && atomic.LoadInt32(&is.n) > 0
+}
+
+// update indexes the encoded value v of k. It is decoded only if an index
+// matches k.
+func (is *indexes) update(k string, v []byte, h *codec.MsgpackHandle) {
+	if !is.active() {
+		return
+	}
+	var (
+		decoded interface{}
+		done    bool
+	)
+	for _, i := range is.list() {
+		if !i.match(k) {
+			continue
+		}
+		if !done {
+			dec := codec.NewDecoderBytes(v, h)
+			if err := dec.Decode(&decoded); err != nil {
+				decoded = nil
+			}
+			done = true
+		}
+		iv, ok := i.fieldValue(decoded)
+		i.set(k, iv, ok)
+	}
+}
+
+func (is *indexes) remove(k string) {
+	if !is.active() {
+		return
+	}
+	for _, i := range is.list() {
+		if i.match(k) {
+			i.set(k, indexValue{}, false)
+		}
+	}
+}
+
+// CreateIndex adds an index over the field of the values of keys matching
+// pattern and fills it from the keys already stored.
+func (s *Store) CreateIndex(name, pattern, field string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	i := newIndex(name, pattern, field)
+	if !s.indexes.add(i) {
+		return IndexExistsError
+	}
+
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	for _, b := range s.allBuckets() {
+		b.reindex(i)
+	}
+	return nil
+}
+
+// reindex adds the keys of b to i.
+func (b *Bucket) reindex(i *index) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for k, v := range b.value {
+		if !i.match(k) {
+			continue
+		}
+		var decoded interface{}
+		dec := codec.NewDecoderBytes(v, b.handle())
+		if err := dec.Decode(&decoded); err != nil {
+			continue
+		}
+		if iv, ok := i.fieldValue(decoded); ok {
+			i.set(k, iv, true)
+		}
+	}
+}
+
+func (s *Store) execIndex(cmd map[string]interface{}) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	switch sub {
+	case "create":
+		args := make([]string, 0, 3)
+		for _, name := range []string{"name", "pattern", "field"} {
+			v, errRes := s.stringArg(cmd, name)
+			if errRes != nil {
+				return errRes
+			}
+			args = append(args, v)
+		}
+		if err := s.CreateIndex(args[0], args[1], args[2]); err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.responseOK()
+	case "drop":
+		name, errRes := s.stringArg(cmd, "name")
+		if errRes != nil {
+			return errRes
+		}
+		if !s.indexes.drop(name) {
+			return s.responseCmdExecuteError(IndexNotFoundError.Error())
+		}
+		return s.responseOK()
+	case "list":
+		is := s.indexes.list()
+		r := make([]interface{}, 0, len(is))
+		for _, i := range is {
+			r = append(r, map[string]interface{}{
+				"name":    i.name,
+				"pattern": i.pattern,
+				"field":   i.field,
+				"keys":    i.len(),
+			})
+		}
+		return s.response(map[string]interface{}{"value": r})
+	case "query":
+		return s.execIndexQuery(cmd)
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// execIndexQuery looks up keys equal to "value", or between "min" and
+// "max" which are both inclusive and optional.
+func (s *Store) execIndexQuery(cmd map[string]interface{}) map[string]interface{} {
+	name, errRes := s.stringArg(cmd, "name")
+	if errRes != nil {
+		return errRes
+	}
+	count, errRes := s.optionalIntArg(cmd, "count", 0)
+	if errRes != nil {
+		return errRes
+	}
+	i := s.indexes.get(name)
+	if i == nil {
+		return s.responseCmdExecuteError(IndexNotFoundError.Error())
+	}
+
+	bound := func(name string) (*indexValue, map[string]interface{}) {
+		v, ok := cmd[name]
+		if !ok {
+			return nil, nil
+		}
+		if name == "value" {
+			var errRes map[string]interface{}
+			if v, errRes = s.valueArg(cmd); errRes != nil {
+				return nil, errRes
+			}
+		}
+		iv, ok := newIndexValue(v)
+		if !ok {
+			return nil, s.responseCmdFormatError(fmt.Sprintf("key '%s' not type string or number", name))
+		}
+		return &iv, nil
+	}
+	min, errRes := bound("min")
+	if errRes != nil {
+		return errRes
+	}
+	max, errRes := bound("max")
+	if errRes != nil {
+		return errRes
+	}
+	eq, errRes := bound("value")
+	if errRes != nil {
+		return errRes
+	}
+	if eq != nil {
+		min, max = eq, eq
+	}
+
+	// Expired keys stay indexed until they are deleted, skip them.
+	keys := make([]interface{}, 0)
+	for _, k := range i.query(min, max, 0) {
+		if count > 0 && int64(len(keys)) == count {
+			break
+		}
+		if s.exists(k) {
+			keys = append(keys, k)
+		}
+	}
+	return s.response(map[string]interface{}{"value": keys})
+}
@@ -0,0 +1,159 @@
+package memds
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestIndex(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 4})
+	users := map[string]map[string]interface{}{
+		"user:1": {"email": "a@example.com", "age": 30},
+		"user:2": {"email": "b@example.com", "age": 25},
+		"user:3": {"email": "c@example.com", "age": 41, "address": map[string]interface{}{"city": "tokyo"}},
+	}
+	for k, v := range users {
+		s.Set(k, v)
+	}
+	s.Set("order:1", map[string]interface{}{"email": "a@example.com"})
+
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		cmd["cmd"] = "index"
+		return s.execute(cmd, new(client))
+	}
+	testCase := []struct {
+		Cmd  map[string]interface{}
+		Keys []interface{}
+	}{
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "email", "value": "a@example.com"},
+			Keys: []interface{}{"user:1"},
+		},
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "age", "min": 26},
+			Keys: []interface{}{"user:1", "user:3"},
+		},
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "age", "min": 20, "max": 30, "count": 1},
+			Keys: []interface{}{"user:2"},
+		},
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "city", "value": []byte("tokyo")},
+			Keys: []interface{}{"user:3"},
+		},
+	}
+
+	for _, c := range []map[string]interface{}{
+		{"sub": "create", "name": "email", "pattern": "user:*", "field": "email"},
+		{"sub": "create", "name": "age", "pattern": "user:*", "field": "age"},
+		{"sub": "create", "name": "city", "pattern": "user:*", "field": "address.city"},
+	} {
+		if res := exec(c); res["status"] != true {
+			t.Fatalf("got: %v", res)
+		}
+	}
+	for _, tc := range testCase {
+		res := exec(tc.Cmd)
+		if !reflect.DeepEqual(res["value"], tc.Keys) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res["value"], tc.Keys)
+		}
+	}
+
+	// Set and Del keep the index up to date.
+	s.Set("user:1", map[string]interface{}{"email": "z@example.com", "age": 30})
+	s.Set("user:4", map[string]interface{}{"email": "a@example.com"})
+	s.Del("user:2")
+	testCase = []struct {
+		Cmd  map[string]interface{}
+		Keys []interface{}
+	}{
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "email", "value": "a@example.com"},
+			Keys: []interface{}{"user:4"},
+		},
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "email", "min": "b", "max": "z{"},
+			Keys: []interface{}{"user:3", "user:1"},
+		},
+		{
+			Cmd:  map[string]interface{}{"sub": "query", "name": "age", "max": 30},
+			Keys: []interface{}{"user:1"},
+		},
+	}
+	for _, tc := range testCase {
+		res := exec(tc.Cmd)
+		if !reflect.DeepEqual(res["value"], tc.Keys) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res["value"], tc.Keys)
+		}
+	}
+
+	s.Flush()
+	if n := s.indexes.get("email").len(); n != 0 {
+		t.Errorf("got: %v, want: 0", n)
+	}
+
+	errCase := []map[string]interface{}{
+		{"sub": "create", "name": "email", "pattern": "user:*", "field": "email"},
+		{"sub": "create", "name": "bad", "pattern": "[", "field": "email"},
+		{"sub": "query", "name": "missing", "value": 1},
+		{"sub": "drop", "name": "missing"},
+	}
+	for _, c := range errCase {
+		if res := exec(c); res["status"] != false {
+			t.Errorf("%v got: %v, want error", c, res)
+		}
+	}
+	if res := exec(map[string]interface{}{"sub": "drop", "name": "email"}); res["status"] != true {
+		t.Errorf("got: %v", res)
+	}
+}
+
+func TestIndexRawValues(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 4, RawValues: true})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		var m map[string]interface{}
+		if err := codec.NewDecoderBytes(s.exec(encodeTest(t, cmd), new(client)), &mh).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	exec(map[string]interface{}{"cmd": "set", "key": "user:1", "value": map[string]interface{}{"email": "a@example.com"}})
+	exec(map[string]interface{}{"cmd": "index", "sub": "create", "name": "email", "pattern": "user:*", "field": "email"})
+	res := exec(map[string]interface{}{"cmd": "index", "sub": "query", "name": "email", "value": "a@example.com"})
+	if !reflect.DeepEqual(toJSON(res["value"]), []interface{}{"user:1"}) {
+		t.Errorf("got: %v, want: [user:1]", res)
+	}
+}
+
+func TestIndexNaN(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 4})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		cmd["cmd"] = "index"
+		return s.execute(cmd, new(client))
+	}
+	exec(map[string]interface{}{"sub": "create", "name": "score", "pattern": "user:*", "field": "score"})
+
+	s.Set("user:1", map[string]interface{}{"score": 1.0})
+	s.Set("user:2", map[string]interface{}{"score": math.NaN()})
+	s.Set("user:3", map[string]interface{}{"score": 3.0})
+	// Updating the NaN valued key leaves the other entries alone.
+	s.Set("user:2", map[string]interface{}{"score": math.NaN()})
+	s.Set("user:2", map[string]interface{}{"score": 2.0})
+
+	res := exec(map[string]interface{}{"sub": "query", "name": "score"})
+	want := []interface{}{"user:1", "user:2", "user:3"}
+	if !reflect.DeepEqual(res["value"], want) {
+		t.Errorf("got: %v, want: %v", res["value"], want)
+	}
+	if n := s.indexes.get("score").len(); n != 3 {
+		t.Errorf("got: %v, want: 3", n)
+	}
+	res = exec(map[string]interface{}{"sub": "query", "name": "score", "min": math.NaN()})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+}
@@ -0,0 +1,110 @@
+package memds
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// infoSections are the sections returned by Info in order.
+var infoSections = []string{
+	"server",
+	"clients",
+	"memory",
+	"keyspace",
+	"persistence",
+	"replication",
+	"commandstats",
+}
+
+// Info returns the named section of the server state, or every section if
+// section is empty. It returns nil for an unknown section.
+func (s *Store) Info(section string) map[string]interface{} {
+	info := make(map[string]interface{})
+	for _, name := range infoSections {
+		if section != "" && section != name {
+			continue
+		}
+		info[name] = s.infoSection(name)
+	}
+	if len(info) == 0 {
+		return nil
+	}
+	return info
+}
+
+func (s *Store) infoSection(name string) map[string]interface{} {
+	switch name {
+	case "server":
+		return map[string]interface{}{
+			"version":        Version,
+			"go_version":     runtime.Version(),
+			"process_id":     os.Getpid(),
+			"uptime_seconds": int64(time.Since(s.started).Seconds()),
+			"resizing":       s.Resizing(),
+		}
+	case "clients":
+		return map[string]interface{}{
+			"connected_clients": atomic.LoadInt64(&s.metrics.clients),
+			"total_connections": atomic.LoadUint64(&s.metrics.connections),
+		}
+	case "memory":
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		var used int64
+		for _, b := range s.statsBuckets() {
+			_, n := b.Stats()
+			used += n
+		}
+		return map[string]interface{}{
+			"used_memory":  used,
+			"heap_alloc":   ms.HeapAlloc,
+			"heap_sys":     ms.HeapSys,
+			"gc_count":     ms.NumGC,
+			"expired_keys": atomic.LoadUint64(&s.metrics.expired),
+		}
+	case "keyspace":
+		buckets := s.statsBuckets()
+		m := make(map[string]interface{}, len(buckets))
+		for i, b := range buckets {
+			keys, n := b.Stats()
+			m["bucket"+strconv.Itoa(i)] = map[string]interface{}{
+				"keys":  keys,
+				"bytes": n,
+			}
+		}
+		return m
+	case "persistence":
+		// memds keeps everything in memory only.
+		return map[string]interface{}{
+			"enabled": false,
+		}
+	case "replication":
+		return map[string]interface{}{
+			"role":             "master",
+			"connected_slaves": 0,
+		}
+	case "commandstats":
+		return s.metrics.commandStats()
+	}
+	return nil
+}
+
+// commandStats returns the call count and total time of each command.
+func (m *Metrics) commandStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]interface{}, len(m.latency))
+	for c, h := range m.latency {
+		usec := int64(h.sum * 1e6)
+		stats["cmdstat_"+c] = map[string]interface{}{
+			"calls":         h.count,
+			"usec":          usec,
+			"usec_per_call": float64(usec) / float64(h.count),
+		}
+	}
+	return stats
+}
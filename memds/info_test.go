@@ -0,0 +1,46 @@
+package memds
+
+import "testing"
+
+func TestInfo(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	s.execute(map[string]interface{}{"cmd": "set", "key": "key", "value": "value"}, new(client))
+
+	info := s.Info("")
+	for _, name := range infoSections {
+		if _, ok := info[name]; !ok {
+			t.Errorf("section '%s' not found", name)
+		}
+	}
+
+	info = s.Info("keyspace")
+	if len(info) != 1 {
+		t.Errorf("got: %v, want: 1", len(info))
+	}
+	keyspace := info["keyspace"].(map[string]interface{})
+	keys := 0
+	for _, v := range keyspace {
+		keys += v.(map[string]interface{})["keys"].(int)
+	}
+	if keys != 1 {
+		t.Errorf("got: %v, want: 1", keys)
+	}
+
+	if s.Info("hoge") != nil {
+		t.Error("should be nil when unknown section")
+	}
+}
+
+func TestConfigMap(t *testing.T) {
+	c := Config{Port: 6700, BucketNum: 10}
+
+	m := c.Map("")
+	if m["port"] != 6700 || m["bucket_num"] != 10 {
+		t.Errorf("got: %v", m)
+	}
+
+	m = c.Map("bucket_n*")
+	if len(m) != 1 || m["bucket_num"] != 10 {
+		t.Errorf("got: %v", m)
+	}
+}
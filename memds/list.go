@@ -0,0 +1,233 @@
+package memds
+
+import "time"
+
+// list is a sequence of values pushed and popped at both ends.
+type list struct {
+	items []interface{}
+}
+
+func newList() interface{} {
+	return new(list)
+}
+
+// list returns the list at k, creating it if create is set. It returns nil
+// if k doesn't exist. The caller must hold b.mu, for writing if create is
+// set.
+func (b *Bucket) list(k string, create bool) (*list, error) {
+	var f func() interface{}
+	if create {
+		f = newList
+	}
+	o, err := b.typed(k, f)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	l, ok := o.(*list)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return l, nil
+}
+
+func (l *list) push(vs []interface{}, left bool) {
+	if !left {
+		l.items = append(l.items, vs...)
+		return
+	}
+	items := make([]interface{}, 0, len(vs)+len(l.items))
+	for i := len(vs) - 1; i >= 0; i-- {
+		items = append(items, vs[i])
+	}
+	l.items = append(items, l.items...)
+}
+
+func (l *list) size() int64 {
+	var n int64
+	for _, v := range l.items {
+		n += valueSize(v)
+	}
+	return n
+}
+
+func (l *list) pop(left bool) interface{} {
+	var v interface{}
+	if left {
+		v = l.items[0]
+		l.items[0] = nil
+		l.items = l.items[1:]
+	} else {
+		n := len(l.items) - 1
+		v = l.items[n]
+		l.items[n] = nil
+		l.items = l.items[:n]
+	}
+	return v
+}
+
+// popList pops a value off the list at k, deleting the key once the list is
+// empty. The caller must hold b.mu for writing.
+func (b *Bucket) popList(k string, left bool) (interface{}, bool, error) {
+	l, err := b.list(k, false)
+	if err != nil || l == nil || len(l.items) == 0 {
+		return nil, false, err
+	}
+	v := l.pop(left)
+	if len(l.items) == 0 {
+		b.remove(k)
+	}
+	return v, true, nil
+}
+
+// rangeIndex converts start and stop, which count from the end if
+// negative, into slice bounds over n items.
+func rangeIndex(start, stop int64, n int) (int, int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func (s *Store) execList(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	if name == "blpop" || name == "brpop" {
+		return s.execBlockingPop(name, cmd, c)
+	}
+
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		switch name {
+		case "lpush", "rpush":
+			vs, errRes := s.valuesArg(cmd)
+			if errRes != nil {
+				res = errRes
+				return nil
+			}
+			l, err := b.list(k, true)
+			if err != nil {
+				return err
+			}
+			l.push(vs, name == "lpush")
+			res = s.response(map[string]interface{}{"value": len(l.items)})
+		case "lpop", "rpop":
+			v, _, err := b.popList(k, name == "lpop")
+			if err != nil {
+				return err
+			}
+			res = s.response(map[string]interface{}{"value": v})
+		case "llen":
+			l, err := b.list(k, false)
+			if err != nil {
+				return err
+			}
+			n := 0
+			if l != nil {
+				n = len(l.items)
+			}
+			res = s.response(map[string]interface{}{"value": n})
+		case "lrange":
+			start, errRes := s.optionalIntArg(cmd, "start", 0)
+			if errRes != nil {
+				res = errRes
+				return nil
+			}
+			stop, errRes := s.optionalIntArg(cmd, "stop", -1)
+			if errRes != nil {
+				res = errRes
+				return nil
+			}
+			l, err := b.list(k, false)
+			if err != nil {
+				return err
+			}
+			r := make([]interface{}, 0)
+			if l != nil {
+				i, j := rangeIndex(start, stop, len(l.items))
+				r = append(r, l.items[i:j]...)
+			}
+			res = s.response(map[string]interface{}{"value": r})
+		}
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if name == "lpush" || name == "rpush" {
+		s.waiters.notify(k)
+	}
+	return res
+}
+
+// execBlockingPop pops from the first of keys holding a value, waiting up
+// to "timeout" seconds for one, 0 waits without limit. Clients waiting on a
+// key are served in the order they started to wait.
+func (s *Store) execBlockingPop(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	keys, errRes := s.stringsArg(cmd, "keys")
+	if errRes != nil {
+		return errRes
+	}
+	timeout, errRes := s.timeoutArg(cmd)
+	if errRes != nil {
+		return errRes
+	}
+
+	var (
+		value interface{}
+		err   error
+	)
+	pop := func(ch chan struct{}) bool {
+		for _, k := range keys {
+			if !s.waiters.turn(k, ch) {
+				continue
+			}
+			err = s.update(k, func(b *Bucket) error {
+				v, ok, err := b.popList(k, name == "blpop")
+				if ok {
+					value = map[string]interface{}{"key": k, "value": v}
+				}
+				return err
+			})
+			if err != nil || value != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !pop(nil) {
+		s.block(keys, timeout, c, pop)
+	}
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.response(map[string]interface{}{"value": value})
+}
+
+// timeoutArg returns cmd["timeout"], in seconds, as a duration. It is 0 if
+// missing.
+func (s *Store) timeoutArg(cmd map[string]interface{}) (time.Duration, map[string]interface{}) {
+	v, ok := cmd["timeout"]
+	if !ok {
+		return 0, nil
+	}
+	f, ok := floatValue(v)
+	if !ok || f < 0 {
+		return 0, s.responseCmdFormatError("key 'timeout' not type number")
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
@@ -0,0 +1,170 @@
+package memds
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	exec(map[string]interface{}{"cmd": "rpush", "key": "l", "values": []interface{}{"b", "c"}})
+	res := exec(map[string]interface{}{"cmd": "lpush", "key": "l", "value": "a"})
+	if res["value"] != 3 {
+		t.Errorf("got: %v, want: 3", res)
+	}
+
+	testCase := []struct {
+		Cmd   map[string]interface{}
+		Value interface{}
+	}{
+		{
+			Cmd:   map[string]interface{}{"cmd": "lrange", "key": "l"},
+			Value: []interface{}{"a", "b", "c"},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "lrange", "key": "l", "start": 1, "stop": -2},
+			Value: []interface{}{"b"},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "lrange", "key": "missing"},
+			Value: []interface{}{},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "lpop", "key": "l"},
+			Value: "a",
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "rpop", "key": "l"},
+			Value: "c",
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "llen", "key": "l"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "lpop", "key": "l"},
+			Value: "b",
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "lpop", "key": "l"},
+			Value: nil,
+		},
+	}
+	for _, tc := range testCase {
+		if res := exec(tc.Cmd); !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res["value"], tc.Value)
+		}
+	}
+	if s.exists("l") {
+		t.Error("got: exists, want: empty list deleted")
+	}
+
+	s.Set("plain", "value")
+	res = exec(map[string]interface{}{"cmd": "lpush", "key": "plain", "value": "a"})
+	if res["msg"] != WrongTypeError.Error() {
+		t.Errorf("got: %v, want: %v", res, WrongTypeError)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	exec(map[string]interface{}{"cmd": "rpush", "key": "b", "value": "x"})
+	res := exec(map[string]interface{}{"cmd": "blpop", "keys": []interface{}{"a", "b"}, "timeout": 1})
+	want := map[string]interface{}{"key": "b", "value": "x"}
+	if !reflect.DeepEqual(res["value"], want) {
+		t.Errorf("got: %v, want: %v", res["value"], want)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "brpop", "keys": "a", "timeout": 0.02})
+	if res["status"] != true || res["value"] != nil {
+		t.Errorf("got: %v, want: nil value after timeout", res)
+	}
+
+	// Waiters are served in the order they started to wait.
+	n := 3
+	done := make(chan map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			done <- exec(map[string]interface{}{"cmd": "blpop", "keys": "a"})
+		}()
+		for !waiting(s, "a", i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	exec(map[string]interface{}{"cmd": "rpush", "key": "a", "values": []interface{}{"0", "1", "2"}})
+	var got []interface{}
+	for i := 0; i < n; i++ {
+		select {
+		case res := <-done:
+			got = append(got, res["value"].(map[string]interface{})["value"])
+		case <-time.After(time.Second):
+			t.Fatal("blocked pop not served")
+		}
+	}
+	if !reflect.DeepEqual(got, []interface{}{"0", "1", "2"}) {
+		t.Errorf("got: %v, want: [0 1 2]", got)
+	}
+
+	go func() {
+		done <- exec(map[string]interface{}{"cmd": "brpop", "keys": "a"})
+	}()
+	for !waiting(s, "a", 1) {
+		time.Sleep(time.Millisecond)
+	}
+	s.StopBlocking()
+	select {
+	case res := <-done:
+		if res["value"] != nil {
+			t.Errorf("got: %v, want: nil value", res)
+		}
+	case <-time.After(time.Second):
+		t.Error("blocked pop not stopped")
+	}
+}
+
+func TestBlockingPopDisconnect(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	c, p := net.Pipe()
+	cl := newClient(c)
+	cl.reader = bufio.NewReader(c)
+
+	done := make(chan map[string]interface{})
+	go func() {
+		done <- s.execute(map[string]interface{}{"cmd": "blpop", "keys": "a"}, cl)
+	}()
+	for !waiting(s, "a", 1) {
+		time.Sleep(time.Millisecond)
+	}
+	p.Close()
+	select {
+	case res := <-done:
+		if res["value"] != nil {
+			t.Errorf("got: %v, want: nil value", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked pop not cancelled")
+	}
+	if waiting(s, "a", 1) {
+		t.Error("got: waiting, want: waiter removed")
+	}
+}
+
+// waiting reports whether n commands are blocked on k.
+func waiting(s *Store, k string, n int) bool {
+	s.waiters.mu.Lock()
+	defer s.waiters.mu.Unlock()
+
+	return len(s.waiters.keys[k]) == n
+}
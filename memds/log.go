@@ -1,6 +1,8 @@
 package memds
 
 import (
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/uber-go/zap"
@@ -8,8 +10,18 @@ import (
 
 var (
 	defaultLogger zap.Logger
+	// logLevel is the lowest zap.Level logged, read with sync/atomic so it
+	// can be changed while serving.
+	logLevel = int32(zap.InfoLevel)
 )
 
+var logLevelNames = map[string]zap.Level{
+	"debug": zap.DebugLevel,
+	"info":  zap.InfoLevel,
+	"warn":  zap.WarnLevel,
+	"error": zap.ErrorLevel,
+}
+
 func init() {
 	enc := zap.NewJSONEncoder(
 		zap.TimeFormatter(func(t time.Time) zap.Field {
@@ -18,21 +30,48 @@ func init() {
 	)
 	defaultLogger = zap.New(
 		enc,
+		zap.DebugLevel,
 	)
 }
 
+// SetLogLevel sets the lowest level logged to one of debug, info, warn or
+// error. An empty name means info.
+func SetLogLevel(name string) error {
+	if name == "" {
+		name = "info"
+	}
+	l, ok := logLevelNames[name]
+	if !ok {
+		return fmt.Errorf("unknown log level: %s", name)
+	}
+	atomic.StoreInt32(&logLevel, int32(l))
+	return nil
+}
+
+func logEnabled(l zap.Level) bool {
+	return int32(l) >= atomic.LoadInt32(&logLevel)
+}
+
 func Debug(msg string, fields ...zap.Field) {
-	defaultLogger.Debug(msg, fields...)
+	if logEnabled(zap.DebugLevel) {
+		defaultLogger.Debug(msg, fields...)
+	}
 }
 
 func Info(msg string, fields ...zap.Field) {
-	defaultLogger.Info(msg, fields...)
+	if logEnabled(zap.InfoLevel) {
+		defaultLogger.Info(msg, fields...)
+	}
 }
 
 func Warn(msg string, fields ...zap.Field) {
-	defaultLogger.Warn(msg, fields...)
+	if logEnabled(zap.WarnLevel) {
+		defaultLogger.Warn(msg, fields...)
+	}
 }
 
 func Error(msg string, fields ...zap.Field) {
-	defaultLogger.Error(msg, fields...)
+	if logEnabled(zap.ErrorLevel) {
+		defaultLogger.Error(msg, fields...)
+	}
 }
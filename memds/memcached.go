@@ -0,0 +1,396 @@
+package memds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	memcachedMaxItemSize = 1024 * 1024
+	memcachedMaxKeyLen   = 250
+	// memcachedMaxRelativeExptime is the largest exptime treated as seconds
+	// from now, larger values are unix timestamps.
+	memcachedMaxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+type mcMode int
+
+const (
+	mcModeSet mcMode = iota
+	mcModeAdd
+	mcModeReplace
+	mcModeCAS
+)
+
+type mcResult int
+
+const (
+	mcStored mcResult = iota
+	mcNotStored
+	mcExists
+	mcNotFound
+	mcNonNumeric
+)
+
+type mcItem struct {
+	value []byte
+	flags uint32
+	cas   uint64
+}
+
+func (s *Server) startMemcached() error {
+	if s.config.MemcachedPort == 0 {
+		return nil
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.MemcachedPort))
+	if err != nil {
+		return err
+	}
+	s.memcachedListener = l
+
+	s.wg.Add(1)
+	go s.serveMemcached(l)
+	return nil
+}
+
+func (s *Server) serveMemcached(l net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil && s.isDraining() {
+			break
+		}
+		if err != nil {
+			Error(err.Error())
+			continue
+		}
+		s.wg.Add(1)
+		go s.acceptMemcached(conn)
+	}
+}
+
+func (s *Server) acceptMemcached(c net.Conn) {
+	closed := make(chan struct{})
+	s.store.metrics.clientConnected()
+
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			c.Close()
+		case <-closed:
+		}
+	}()
+
+	defer func() {
+		close(closed)
+		c.Close()
+		s.forget(c)
+		s.store.metrics.clientDisconnected()
+		s.wg.Done()
+	}()
+
+	if s.isDraining() {
+		return
+	}
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(deadlineWriter{s: s, c: c})
+
+	// The first byte of a request tells the binary protocol from the text one.
+	if err := s.waitCommand(c, r); err != nil {
+		return
+	}
+	p, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+
+	cl := newClient(c)
+	cl.streams = false
+	if !s.store.clients.add(cl, s.store.Config().MaxClients) {
+		if p[0] != mcBinaryReqMagic {
+			s.setWriteDeadline(c)
+			c.Write([]byte("SERVER_ERROR " + MaxClientsError.Error() + "\r\n"))
+		}
+		Warn(fmt.Sprintf("reject %s: %v", cl.addr, MaxClientsError))
+		return
+	}
+	defer s.store.clients.remove(cl)
+	cl.awaitCommand = func() error {
+		return s.waitCommand(c, r)
+	}
+
+	if p[0] == mcBinaryReqMagic {
+		err = s.store.serveMemcachedBinary(r, w, cl)
+	} else {
+		err = s.store.serveMemcachedText(r, w, cl)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && !s.isDraining() {
+		Info(fmt.Sprintf("close %s: %v", cl.addr, err))
+	} else if err != nil && err != io.EOF && !s.isDraining() {
+		Error(fmt.Sprintf("%v", err))
+	}
+}
+
+// mcExec runs fn on the bucket of k for the memcached command name sent by
+// c. Like the commands run by execute, it waits for a running script and is
+// recorded in the store metrics, slowlog and monitors.
+func (s *Store) mcExec(c *client, name, k string, fn func(b *Bucket)) {
+	cmd := map[string]interface{}{"cmd": name, "key": k}
+	s.run(cmd, name, false, c, func() map[string]interface{} {
+		s.bmu.RLock()
+		defer s.bmu.RUnlock()
+
+		if b := s.bucket(k); b != nil {
+			fn(b)
+		}
+		return s.responseOK()
+	})
+}
+
+func (s *Store) mcGet(c *client, k string) (it mcItem, ok bool) {
+	s.mcExec(c, "memcached.get", k, func(b *Bucket) {
+		it, ok = b.mcGet(k)
+	})
+	return it, ok
+}
+
+func (s *Store) mcStore(c *client, mode mcMode, k string, v []byte, flags uint32, exptime int64, cas uint64) (mcItem, mcResult) {
+	it, res := mcItem{}, mcNotStored
+	s.mcExec(c, "memcached.store", k, func(b *Bucket) {
+		it, res = b.mcStore(mode, k, v, flags, mcExpire(exptime, time.Now()), cas)
+	})
+	return it, res
+}
+
+func (s *Store) mcIncr(c *client, k string, delta uint64, incr bool) (mcItem, mcResult) {
+	it, res := mcItem{}, mcNotFound
+	s.mcExec(c, "memcached.incr", k, func(b *Bucket) {
+		it, res = b.mcIncr(k, delta, incr)
+	})
+	return it, res
+}
+
+func (s *Store) mcTouch(c *client, k string, exptime int64) (it mcItem, ok bool) {
+	s.mcExec(c, "memcached.touch", k, func(b *Bucket) {
+		it, ok = b.mcTouch(k, mcExpire(exptime, time.Now()))
+	})
+	return it, ok
+}
+
+func (s *Store) mcDelete(c *client, k string) (ok bool) {
+	s.mcExec(c, "memcached.delete", k, func(b *Bucket) {
+		ok = b.mcDelete(k)
+	})
+	return ok
+}
+
+func (s *Store) mcFlush(c *client) {
+	cmd := map[string]interface{}{"cmd": "memcached.flush_all"}
+	s.run(cmd, "memcached.flush_all", false, c, func() map[string]interface{} {
+		s.Flush()
+		return s.responseOK()
+	})
+}
+
+// mcExpire converts a memcached exptime into an expiry time. Zero means no
+// expiry and negative values are already expired.
+func mcExpire(exptime int64, now time.Time) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return now.Add(-time.Second)
+	case exptime > memcachedMaxRelativeExptime:
+		return time.Unix(exptime, 0)
+	default:
+		return now.Add(time.Duration(exptime) * time.Second)
+	}
+}
+
+func (b *Bucket) mcGet(k string) (mcItem, bool) {
+	now := time.Now()
+
+	// Values are decoded under the lock, bitmap commands change them in
+	// place.
+	var (
+		bs  []byte
+		err error
+	)
+	b.mu.RLock()
+	v, m, ok := b.lookup(k, now)
+	if ok && m != nil {
+		bs, err = b.mcDecode(v)
+	}
+	b.mu.RUnlock()
+	if !ok {
+		return mcItem{}, false
+	}
+
+	if m == nil {
+		// Keys written through Set have no cas yet, give them one so gets
+		// and cas work on them.
+		b.mu.Lock()
+		v, m, ok = b.lookup(k, now)
+		if ok && m == nil {
+			m = b.setMeta(k, 0, time.Time{})
+		}
+		if ok {
+			bs, err = b.mcDecode(v)
+		}
+		b.mu.Unlock()
+		if !ok {
+			return mcItem{}, false
+		}
+	}
+
+	if err != nil {
+		Error(fmt.Sprintf("memcached decode error: %v", err))
+		return mcItem{}, false
+	}
+	return mcItem{value: bs, flags: m.flags, cas: m.cas}, true
+}
+
+func (b *Bucket) mcStore(mode mcMode, k string, v []byte, flags uint32, expire time.Time, cas uint64) (mcItem, mcResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	// Only set replaces a typed value like a list, the other modes leave
+	// it alone.
+	if _, typed := b.object(k, now); typed && mode != mcModeSet {
+		return mcItem{}, mcNotStored
+	}
+	_, m, ok := b.lookup(k, now)
+	switch mode {
+	case mcModeAdd:
+		if ok {
+			return mcItem{}, mcNotStored
+		}
+	case mcModeReplace:
+		if !ok {
+			return mcItem{}, mcNotStored
+		}
+	case mcModeCAS:
+		if !ok {
+			return mcItem{}, mcNotFound
+		}
+		if m == nil || m.cas != cas {
+			return mcItem{}, mcExists
+		}
+	}
+
+	var bs []byte
+	enc := codec.NewEncoderBytes(&bs, b.handle())
+	if err := enc.Encode(v); err != nil {
+		Error(fmt.Sprintf("memcached encode error: %v", err))
+		return mcItem{}, mcNotStored
+	}
+	b.put(k, bs)
+	m = b.setMeta(k, flags, expire)
+	return mcItem{value: v, flags: m.flags, cas: m.cas}, mcStored
+}
+
+func (b *Bucket) mcIncr(k string, delta uint64, incr bool) (mcItem, mcResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, m, ok := b.lookup(k, time.Now())
+	if !ok {
+		return mcItem{}, mcNotFound
+	}
+	bs, err := b.mcDecode(v)
+	if err != nil {
+		return mcItem{}, mcNonNumeric
+	}
+	n, err := strconv.ParseUint(string(bs), 10, 64)
+	if err != nil {
+		return mcItem{}, mcNonNumeric
+	}
+
+	switch {
+	case incr:
+		n += delta
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+
+	var (
+		flags  uint32
+		expire time.Time
+	)
+	if m != nil {
+		flags = m.flags
+		expire = m.expire
+	}
+
+	bs = []byte(strconv.FormatUint(n, 10))
+	v = nil
+	enc := codec.NewEncoderBytes(&v, b.handle())
+	if err := enc.Encode(bs); err != nil {
+		return mcItem{}, mcNotStored
+	}
+	b.put(k, v)
+	m = b.setMeta(k, flags, expire)
+	return mcItem{value: bs, flags: m.flags, cas: m.cas}, mcStored
+}
+
+func (b *Bucket) mcTouch(k string, expire time.Time) (mcItem, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, m, ok := b.lookup(k, time.Now())
+	if !ok {
+		return mcItem{}, false
+	}
+
+	var flags uint32
+	if m != nil {
+		flags = m.flags
+	}
+	m = b.setMeta(k, flags, expire)
+
+	bs, err := b.mcDecode(v)
+	if err != nil {
+		return mcItem{}, false
+	}
+	return mcItem{value: bs, flags: m.flags, cas: m.cas}, true
+}
+
+func (b *Bucket) mcDelete(k string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	_, _, ok := b.lookup(k, now)
+	_, typed := b.object(k, now)
+	b.remove(k)
+	return ok || typed
+}
+
+// mcDecode returns the bytes memcached clients see for an encoded value.
+// Values set through memds that are not raw bytes are formatted as text.
+func (b *Bucket) mcDecode(v []byte) ([]byte, error) {
+	var r interface{}
+	dec := codec.NewDecoderBytes(v, b.handle())
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	switch t := r.(type) {
+	case []uint8:
+		return t, nil
+	case string:
+		return []byte(t), nil
+	default:
+		return []byte(fmt.Sprintf("%v", t)), nil
+	}
+}
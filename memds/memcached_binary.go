@@ -0,0 +1,270 @@
+package memds
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"strconv"
+)
+
+const (
+	mcBinaryReqMagic  = 0x80
+	mcBinaryResMagic  = 0x81
+	mcBinaryHeaderLen = 24
+	// mcBinaryNoInitial in the incr/decr exptime means fail on a missing key
+	// instead of creating it.
+	mcBinaryNoInitial = 0xffffffff
+)
+
+const (
+	mcOpGet        = 0x00
+	mcOpSet        = 0x01
+	mcOpAdd        = 0x02
+	mcOpReplace    = 0x03
+	mcOpDelete     = 0x04
+	mcOpIncrement  = 0x05
+	mcOpDecrement  = 0x06
+	mcOpQuit       = 0x07
+	mcOpFlush      = 0x08
+	mcOpGetQ       = 0x09
+	mcOpNoop       = 0x0a
+	mcOpVersion    = 0x0b
+	mcOpGetK       = 0x0c
+	mcOpGetKQ      = 0x0d
+	mcOpStat       = 0x10
+	mcOpSetQ       = 0x11
+	mcOpAddQ       = 0x12
+	mcOpReplaceQ   = 0x13
+	mcOpDeleteQ    = 0x14
+	mcOpIncrementQ = 0x15
+	mcOpDecrementQ = 0x16
+	mcOpQuitQ      = 0x17
+	mcOpFlushQ     = 0x18
+	mcOpTouch      = 0x1c
+	mcOpGAT        = 0x1d
+	mcOpGATQ       = 0x1e
+)
+
+const (
+	mcStatusOK             = 0x00
+	mcStatusKeyNotFound    = 0x01
+	mcStatusKeyExists      = 0x02
+	mcStatusValueTooLarge  = 0x03
+	mcStatusInvalidArgs    = 0x04
+	mcStatusNotStored      = 0x05
+	mcStatusNonNumeric     = 0x06
+	mcStatusUnknownCommand = 0x81
+)
+
+type mcBinaryHeader struct {
+	opcode   uint8
+	keyLen   uint16
+	extraLen uint8
+	bodyLen  uint32
+	opaque   uint32
+	cas      uint64
+}
+
+type mcBinaryResponse struct {
+	status uint16
+	extras []byte
+	key    []byte
+	value  []byte
+	cas    uint64
+}
+
+func (s *Store) serveMemcachedBinary(r *bufio.Reader, w *bufio.Writer, c *client) error {
+	hb := make([]byte, mcBinaryHeaderLen)
+	for {
+		if c.awaitCommand != nil {
+			if err := c.awaitCommand(); err != nil {
+				return err
+			}
+		}
+		if _, err := io.ReadFull(r, hb); err != nil {
+			return err
+		}
+		if hb[0] != mcBinaryReqMagic {
+			return io.ErrUnexpectedEOF
+		}
+		h := mcBinaryHeader{
+			opcode:   hb[1],
+			keyLen:   binary.BigEndian.Uint16(hb[2:4]),
+			extraLen: hb[4],
+			bodyLen:  binary.BigEndian.Uint32(hb[8:12]),
+			opaque:   binary.BigEndian.Uint32(hb[12:16]),
+			cas:      binary.BigEndian.Uint64(hb[16:24]),
+		}
+		if int(h.keyLen)+int(h.extraLen) > int(h.bodyLen) || h.bodyLen > memcachedMaxItemSize+mcBinaryHeaderLen+memcachedMaxKeyLen {
+			mcWriteBinary(w, h, mcBinaryResponse{status: mcStatusInvalidArgs})
+			return w.Flush()
+		}
+
+		body := make([]byte, h.bodyLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		extras := body[:h.extraLen]
+		key := body[h.extraLen : int(h.extraLen)+int(h.keyLen)]
+		value := body[int(h.extraLen)+int(h.keyLen):]
+
+		res, quiet, quit := s.execMemcachedBinary(h, extras, key, value, c)
+		if !quiet {
+			mcWriteBinary(w, h, res)
+		}
+		if quit {
+			return w.Flush()
+		}
+
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// execMemcachedBinary runs one request and reports whether the response is
+// suppressed by a quiet opcode and whether the connection should be closed.
+func (s *Store) execMemcachedBinary(h mcBinaryHeader, extras, key, value []byte, c *client) (mcBinaryResponse, bool, bool) {
+	k := string(key)
+
+	switch h.opcode {
+	case mcOpGet, mcOpGetQ, mcOpGetK, mcOpGetKQ, mcOpGAT, mcOpGATQ:
+		var (
+			it mcItem
+			ok bool
+		)
+		if h.opcode == mcOpGAT || h.opcode == mcOpGATQ {
+			if len(extras) != 4 {
+				return mcBinaryResponse{status: mcStatusInvalidArgs}, false, false
+			}
+			it, ok = s.mcTouch(c, k, int64(int32(binary.BigEndian.Uint32(extras))))
+		} else {
+			it, ok = s.mcGet(c, k)
+		}
+		quiet := h.opcode == mcOpGetQ || h.opcode == mcOpGetKQ || h.opcode == mcOpGATQ
+		if !ok {
+			return mcBinaryResponse{status: mcStatusKeyNotFound}, quiet, false
+		}
+		res := mcBinaryResponse{
+			extras: make([]byte, 4),
+			value:  it.value,
+			cas:    it.cas,
+		}
+		binary.BigEndian.PutUint32(res.extras, it.flags)
+		if h.opcode == mcOpGetK || h.opcode == mcOpGetKQ {
+			res.key = key
+		}
+		return res, false, false
+	case mcOpSet, mcOpSetQ, mcOpAdd, mcOpAddQ, mcOpReplace, mcOpReplaceQ:
+		if len(extras) != 8 {
+			return mcBinaryResponse{status: mcStatusInvalidArgs}, false, false
+		}
+		if len(value) > memcachedMaxItemSize {
+			return mcBinaryResponse{status: mcStatusValueTooLarge}, false, false
+		}
+		mode := mcModeSet
+		switch h.opcode {
+		case mcOpAdd, mcOpAddQ:
+			mode = mcModeAdd
+		case mcOpReplace, mcOpReplaceQ:
+			mode = mcModeReplace
+		}
+		if h.cas != 0 && mode != mcModeAdd {
+			mode = mcModeCAS
+		}
+		flags := binary.BigEndian.Uint32(extras[0:4])
+		exptime := int64(int32(binary.BigEndian.Uint32(extras[4:8])))
+
+		it, r := s.mcStore(c, mode, k, value, flags, exptime, h.cas)
+		quiet := h.opcode == mcOpSetQ || h.opcode == mcOpAddQ || h.opcode == mcOpReplaceQ
+		switch r {
+		case mcStored:
+			return mcBinaryResponse{cas: it.cas}, quiet, false
+		case mcExists:
+			return mcBinaryResponse{status: mcStatusKeyExists}, false, false
+		case mcNotFound:
+			return mcBinaryResponse{status: mcStatusKeyNotFound}, false, false
+		case mcNotStored:
+			if mode == mcModeAdd {
+				return mcBinaryResponse{status: mcStatusKeyExists}, false, false
+			}
+			return mcBinaryResponse{status: mcStatusNotStored}, false, false
+		}
+		return mcBinaryResponse{status: mcStatusNotStored}, false, false
+	case mcOpDelete, mcOpDeleteQ:
+		if !s.mcDelete(c, k) {
+			return mcBinaryResponse{status: mcStatusKeyNotFound}, false, false
+		}
+		return mcBinaryResponse{}, h.opcode == mcOpDeleteQ, false
+	case mcOpIncrement, mcOpIncrementQ, mcOpDecrement, mcOpDecrementQ:
+		if len(extras) != 20 {
+			return mcBinaryResponse{status: mcStatusInvalidArgs}, false, false
+		}
+		delta := binary.BigEndian.Uint64(extras[0:8])
+		initial := binary.BigEndian.Uint64(extras[8:16])
+		exptime := binary.BigEndian.Uint32(extras[16:20])
+		incr := h.opcode == mcOpIncrement || h.opcode == mcOpIncrementQ
+		quiet := h.opcode == mcOpIncrementQ || h.opcode == mcOpDecrementQ
+
+		it, r := s.mcIncr(c, k, delta, incr)
+		if r == mcNotFound && exptime != mcBinaryNoInitial {
+			it, r = s.mcStore(c, mcModeAdd, k, []byte(strconv.FormatUint(initial, 10)), 0, int64(int32(exptime)), 0)
+			if r == mcNotStored {
+				// Lost a race with another writer, apply the delta to its value.
+				it, r = s.mcIncr(c, k, delta, incr)
+			}
+		}
+		switch r {
+		case mcStored:
+			n, _ := strconv.ParseUint(string(it.value), 10, 64)
+			res := mcBinaryResponse{
+				value: make([]byte, 8),
+				cas:   it.cas,
+			}
+			binary.BigEndian.PutUint64(res.value, n)
+			return res, quiet, false
+		case mcNonNumeric:
+			return mcBinaryResponse{status: mcStatusNonNumeric}, false, false
+		}
+		return mcBinaryResponse{status: mcStatusKeyNotFound}, false, false
+	case mcOpTouch:
+		if len(extras) != 4 {
+			return mcBinaryResponse{status: mcStatusInvalidArgs}, false, false
+		}
+		it, ok := s.mcTouch(c, k, int64(int32(binary.BigEndian.Uint32(extras))))
+		if !ok {
+			return mcBinaryResponse{status: mcStatusKeyNotFound}, false, false
+		}
+		return mcBinaryResponse{cas: it.cas}, false, false
+	case mcOpFlush, mcOpFlushQ:
+		s.mcFlush(c)
+		return mcBinaryResponse{}, h.opcode == mcOpFlushQ, false
+	case mcOpNoop, mcOpStat:
+		return mcBinaryResponse{}, false, false
+	case mcOpVersion:
+		return mcBinaryResponse{value: []byte(Version)}, false, false
+	case mcOpQuit, mcOpQuitQ:
+		return mcBinaryResponse{}, h.opcode == mcOpQuitQ, true
+	default:
+		return mcBinaryResponse{status: mcStatusUnknownCommand}, false, false
+	}
+}
+
+func mcWriteBinary(w *bufio.Writer, h mcBinaryHeader, res mcBinaryResponse) {
+	hb := make([]byte, mcBinaryHeaderLen)
+	hb[0] = mcBinaryResMagic
+	hb[1] = h.opcode
+	binary.BigEndian.PutUint16(hb[2:4], uint16(len(res.key)))
+	hb[4] = uint8(len(res.extras))
+	binary.BigEndian.PutUint16(hb[6:8], res.status)
+	binary.BigEndian.PutUint32(hb[8:12], uint32(len(res.extras)+len(res.key)+len(res.value)))
+	binary.BigEndian.PutUint32(hb[12:16], h.opaque)
+	binary.BigEndian.PutUint64(hb[16:24], res.cas)
+
+	w.Write(hb)
+	w.Write(res.extras)
+	w.Write(res.key)
+	w.Write(res.value)
+}
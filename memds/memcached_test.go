@@ -0,0 +1,275 @@
+package memds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func execMemcachedText(s *Store, in string) string {
+	var out bytes.Buffer
+	r := bufio.NewReader(strings.NewReader(in))
+	w := bufio.NewWriter(&out)
+	s.serveMemcachedText(r, w, new(client))
+	w.Flush()
+	return out.String()
+}
+
+func TestMemcachedText(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	testCase := []struct {
+		In  string
+		Out string
+	}{
+		{
+			In:  "get key\r\n",
+			Out: "END\r\n",
+		},
+		{
+			In:  "set key 5 0 5\r\nvalue\r\n",
+			Out: "STORED\r\n",
+		},
+		{
+			In:  "get key\r\n",
+			Out: "VALUE key 5 5\r\nvalue\r\nEND\r\n",
+		},
+		{
+			In:  "add key 0 0 1\r\nx\r\n",
+			Out: "NOT_STORED\r\n",
+		},
+		{
+			In:  "replace key1 0 0 1\r\nx\r\n",
+			Out: "NOT_STORED\r\n",
+		},
+		{
+			In:  "set key 0 0 5 noreply\r\nvalue\r\nget key\r\n",
+			Out: "VALUE key 0 5\r\nvalue\r\nEND\r\n",
+		},
+		{
+			In:  "set n 0 0 2\r\n10\r\nincr n 5\r\ndecr n 20\r\n",
+			Out: "STORED\r\n15\r\n0\r\n",
+		},
+		{
+			In:  "incr key 1\r\n",
+			Out: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n",
+		},
+		{
+			In:  "touch key 100\r\ntouch key1 100\r\n",
+			Out: "TOUCHED\r\nNOT_FOUND\r\n",
+		},
+		{
+			In:  "delete key\r\ndelete key\r\n",
+			Out: "DELETED\r\nNOT_FOUND\r\n",
+		},
+		{
+			In:  "set key 0 -1 5\r\nvalue\r\nget key\r\n",
+			Out: "STORED\r\nEND\r\n",
+		},
+		{
+			In:  "set key 0 0 5\r\nvalue!\r\n",
+			Out: "CLIENT_ERROR bad data chunk\r\n",
+		},
+		{
+			In:  "unknown\r\n",
+			Out: "ERROR\r\n",
+		},
+	}
+
+	for _, tc := range testCase {
+		out := execMemcachedText(s, tc.In)
+		if out != tc.Out {
+			t.Errorf("in: %q, got: %q, want: %q", tc.In, out, tc.Out)
+		}
+	}
+}
+
+func TestMemcachedTextCAS(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	s.Set("key", []byte("value"))
+
+	it, ok := s.mcGet(new(client), "key")
+	if !ok {
+		t.Fatal("key not found")
+	}
+
+	out := execMemcachedText(s, "cas key 0 0 1 "+strconv.FormatUint(it.cas+1, 10)+"\r\nx\r\n")
+	if out != "EXISTS\r\n" {
+		t.Errorf("got: %q, want: %q", out, "EXISTS\r\n")
+	}
+	out = execMemcachedText(s, "cas key 0 0 1 "+strconv.FormatUint(it.cas, 10)+"\r\nx\r\n")
+	if out != "STORED\r\n" {
+		t.Errorf("got: %q, want: %q", out, "STORED\r\n")
+	}
+	out = execMemcachedText(s, "cas key1 0 0 1 1\r\nx\r\n")
+	if out != "NOT_FOUND\r\n" {
+		t.Errorf("got: %q, want: %q", out, "NOT_FOUND\r\n")
+	}
+
+	v, _ := s.Get("key")
+	if !reflect.DeepEqual(v, []byte("x")) {
+		t.Errorf("got: %v, want: %v", v, []byte("x"))
+	}
+}
+
+func TestMemcachedTextTyped(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	s.execute(map[string]interface{}{"cmd": "rpush", "key": "l", "values": []interface{}{"a"}}, new(client))
+
+	testCase := []struct {
+		In  string
+		Out string
+	}{
+		{
+			In:  "add l 0 0 1\r\nx\r\n",
+			Out: "NOT_STORED\r\n",
+		},
+		{
+			In:  "replace l 0 0 1\r\nx\r\n",
+			Out: "NOT_STORED\r\n",
+		},
+		{
+			In:  "cas l 0 0 1 1\r\nx\r\n",
+			Out: "NOT_STORED\r\n",
+		},
+	}
+	for _, tc := range testCase {
+		out := execMemcachedText(s, tc.In)
+		if out != tc.Out {
+			t.Errorf("in: %q, got: %q, want: %q", tc.In, out, tc.Out)
+		}
+	}
+	res := s.execute(map[string]interface{}{"cmd": "llen", "key": "l"}, new(client))
+	if res["value"] != 1 {
+		t.Errorf("got: %v, want: 1", res)
+	}
+
+	out := execMemcachedText(s, "delete l\r\ndelete l\r\n")
+	if out != "DELETED\r\nNOT_FOUND\r\n" {
+		t.Errorf("got: %q, want: %q", out, "DELETED\r\nNOT_FOUND\r\n")
+	}
+}
+
+func TestMemcachedBinary(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	req := func(op uint8, extras, key, value []byte) []byte {
+		h := make([]byte, mcBinaryHeaderLen)
+		h[0] = mcBinaryReqMagic
+		h[1] = op
+		binary.BigEndian.PutUint16(h[2:4], uint16(len(key)))
+		h[4] = uint8(len(extras))
+		binary.BigEndian.PutUint32(h[8:12], uint32(len(extras)+len(key)+len(value)))
+		h = append(h, extras...)
+		h = append(h, key...)
+		return append(h, value...)
+	}
+
+	setExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(setExtras[0:4], 3)
+
+	var in []byte
+	in = append(in, req(mcOpSet, setExtras, []byte("key"), []byte("value"))...)
+	in = append(in, req(mcOpGetQ, nil, []byte("key1"), nil)...)
+	in = append(in, req(mcOpGet, nil, []byte("key"), nil)...)
+	in = append(in, req(mcOpDelete, nil, []byte("key"), nil)...)
+	in = append(in, req(mcOpGet, nil, []byte("key"), nil)...)
+
+	var out bytes.Buffer
+	r := bufio.NewReader(bytes.NewReader(in))
+	w := bufio.NewWriter(&out)
+	s.serveMemcachedBinary(r, w, new(client))
+	w.Flush()
+
+	testCase := []struct {
+		Op     uint8
+		Status uint16
+		Extras []byte
+		Value  []byte
+	}{
+		{Op: mcOpSet, Status: mcStatusOK},
+		{Op: mcOpGet, Status: mcStatusOK, Extras: []byte{0, 0, 0, 3}, Value: []byte("value")},
+		{Op: mcOpDelete, Status: mcStatusOK},
+		{Op: mcOpGet, Status: mcStatusKeyNotFound},
+	}
+
+	b := out.Bytes()
+	for _, tc := range testCase {
+		if len(b) < mcBinaryHeaderLen {
+			t.Fatalf("response too short: %v", b)
+		}
+		status := binary.BigEndian.Uint16(b[6:8])
+		extraLen := int(b[4])
+		bodyLen := int(binary.BigEndian.Uint32(b[8:12]))
+		body := b[mcBinaryHeaderLen : mcBinaryHeaderLen+bodyLen]
+		b = b[mcBinaryHeaderLen+bodyLen:]
+
+		if status != tc.Status {
+			t.Errorf("op: %v, got: %v, want: %v", tc.Op, status, tc.Status)
+		}
+		if tc.Extras != nil && !bytes.Equal(body[:extraLen], tc.Extras) {
+			t.Errorf("op: %v, got: %v, want: %v", tc.Op, body[:extraLen], tc.Extras)
+		}
+		if tc.Value != nil && !bytes.Equal(body[extraLen:], tc.Value) {
+			t.Errorf("op: %v, got: %v, want: %v", tc.Op, body[extraLen:], tc.Value)
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected responses: %v", b)
+	}
+}
+
+func TestMemcachedExecute(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	// A running script holds memcached commands back.
+	s.smu.Lock()
+	done := make(chan string)
+	go func() {
+		done <- execMemcachedText(s, "set key 0 0 1\r\nv\r\n")
+	}()
+	select {
+	case out := <-done:
+		t.Fatalf("got: %q while a script runs, want: to wait", out)
+	case <-time.After(20 * time.Millisecond):
+	}
+	s.smu.Unlock()
+	if out := <-done; out != "STORED\r\n" {
+		t.Errorf("got: %q, want: STORED", out)
+	}
+
+	execMemcachedText(s, "get key\r\n")
+	var b bytes.Buffer
+	s.WriteMetrics(&b)
+	for _, l := range []string{
+		`memds_commands_total{cmd="memcached.store",code="ok"} 1`,
+		`memds_commands_total{cmd="memcached.get",code="ok"} 1`,
+	} {
+		if !strings.Contains(b.String(), l+"\n") {
+			t.Errorf("%q not found in:\n%s", l, b.String())
+		}
+	}
+}
+
+func TestMcExpire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	testCase := []struct {
+		In     int64
+		Result time.Time
+	}{
+		{In: 0, Result: time.Time{}},
+		{In: -1, Result: now.Add(-time.Second)},
+		{In: 10, Result: now.Add(10 * time.Second)},
+		{In: memcachedMaxRelativeExptime + 1, Result: time.Unix(memcachedMaxRelativeExptime+1, 0)},
+	}
+	for _, tc := range testCase {
+		if r := mcExpire(tc.In, now); !r.Equal(tc.Result) {
+			t.Errorf("in: %v, got: %v, want: %v", tc.In, r, tc.Result)
+		}
+	}
+}
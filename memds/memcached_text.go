@@ -0,0 +1,237 @@
+package memds
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	mcTextError             = "ERROR\r\n"
+	mcTextBadFormat         = "CLIENT_ERROR bad command line format\r\n"
+	mcTextBadDataChunk      = "CLIENT_ERROR bad data chunk\r\n"
+	mcTextNonNumeric        = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	mcTextTooLarge          = "SERVER_ERROR object too large for cache\r\n"
+	mcTextStored            = "STORED\r\n"
+	mcTextNotStored         = "NOT_STORED\r\n"
+	mcTextExists            = "EXISTS\r\n"
+	mcTextNotFound          = "NOT_FOUND\r\n"
+	mcTextDeleted           = "DELETED\r\n"
+	mcTextTouched           = "TOUCHED\r\n"
+	mcTextOK                = "OK\r\n"
+	mcTextEnd               = "END\r\n"
+	mcTextNoReply           = "noreply"
+	mcTextVersionPrefix     = "VERSION "
+	mcTextValuePrefix       = "VALUE "
+	mcTextStorageArgsMin    = 5
+	mcTextStorageArgsMinCAS = 6
+)
+
+var mcStoreModes = map[string]mcMode{
+	"set":     mcModeSet,
+	"add":     mcModeAdd,
+	"replace": mcModeReplace,
+	"cas":     mcModeCAS,
+}
+
+func (s *Store) serveMemcachedText(r *bufio.Reader, w *bufio.Writer, c *client) error {
+	for {
+		if c.awaitCommand != nil {
+			if err := c.awaitCommand(); err != nil {
+				return err
+			}
+		}
+		line, err := mcReadLine(r)
+		if err != nil {
+			return err
+		}
+
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			w.WriteString(mcTextError)
+		} else if tokens[0] == "quit" {
+			return w.Flush()
+		} else if err := s.execMemcachedText(tokens, r, w, c); err != nil {
+			return err
+		}
+
+		// Flush once every pipelined command has been answered.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Store) execMemcachedText(tokens []string, r *bufio.Reader, w *bufio.Writer, c *client) error {
+	switch tokens[0] {
+	case "get", "gets":
+		if len(tokens) < 2 {
+			w.WriteString(mcTextError)
+			return nil
+		}
+		for _, k := range tokens[1:] {
+			it, ok := s.mcGet(c, k)
+			if !ok {
+				continue
+			}
+			w.WriteString(mcTextValuePrefix)
+			w.WriteString(k)
+			fmt.Fprintf(w, " %d %d", it.flags, len(it.value))
+			if tokens[0] == "gets" {
+				fmt.Fprintf(w, " %d", it.cas)
+			}
+			w.WriteString("\r\n")
+			w.Write(it.value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString(mcTextEnd)
+	case "set", "add", "replace", "cas":
+		return s.execMemcachedTextStore(mcStoreModes[tokens[0]], tokens, r, w, c)
+	case "delete":
+		if len(tokens) < 2 || len(tokens) > 3 {
+			w.WriteString(mcTextError)
+			return nil
+		}
+		res := mcTextNotFound
+		if s.mcDelete(c, tokens[1]) {
+			res = mcTextDeleted
+		}
+		mcReply(w, tokens, 2, res)
+	case "incr", "decr":
+		if len(tokens) < 3 {
+			w.WriteString(mcTextError)
+			return nil
+		}
+		delta, err := strconv.ParseUint(tokens[2], 10, 64)
+		if err != nil {
+			w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+			return nil
+		}
+		it, res := s.mcIncr(c, tokens[1], delta, tokens[0] == "incr")
+		switch res {
+		case mcStored:
+			mcReply(w, tokens, 3, string(it.value)+"\r\n")
+		case mcNonNumeric:
+			mcReply(w, tokens, 3, mcTextNonNumeric)
+		default:
+			mcReply(w, tokens, 3, mcTextNotFound)
+		}
+	case "touch":
+		if len(tokens) < 3 {
+			w.WriteString(mcTextError)
+			return nil
+		}
+		exptime, err := strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+			return nil
+		}
+		res := mcTextNotFound
+		if _, ok := s.mcTouch(c, tokens[1], exptime); ok {
+			res = mcTextTouched
+		}
+		mcReply(w, tokens, 3, res)
+	case "flush_all":
+		s.mcFlush(c)
+		mcReply(w, tokens, len(tokens)-1, mcTextOK)
+	case "version":
+		w.WriteString(mcTextVersionPrefix + Version + "\r\n")
+	default:
+		w.WriteString(mcTextError)
+	}
+	return nil
+}
+
+// execMemcachedTextStore runs set, add, replace and cas:
+//
+//	<cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Store) execMemcachedTextStore(mode mcMode, tokens []string, r *bufio.Reader, w *bufio.Writer, c *client) error {
+	argsMin := mcTextStorageArgsMin
+	if mode == mcModeCAS {
+		argsMin = mcTextStorageArgsMinCAS
+	}
+	if len(tokens) < argsMin || len(tokens) > argsMin+1 {
+		w.WriteString(mcTextError)
+		return nil
+	}
+
+	k := tokens[1]
+	flags, err0 := strconv.ParseUint(tokens[2], 10, 32)
+	exptime, err1 := strconv.ParseInt(tokens[3], 10, 64)
+	n, err2 := strconv.Atoi(tokens[4])
+	var (
+		cas  uint64
+		err3 error
+	)
+	if mode == mcModeCAS {
+		cas, err3 = strconv.ParseUint(tokens[5], 10, 64)
+	}
+	if err0 != nil || err1 != nil || err2 != nil || err3 != nil || n < 0 || len(k) > memcachedMaxKeyLen {
+		w.WriteString(mcTextBadFormat)
+		return nil
+	}
+
+	if n > memcachedMaxItemSize {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)+2); err != nil {
+			return err
+		}
+		mcReply(w, tokens, argsMin, mcTextTooLarge)
+		return nil
+	}
+
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		// Skip the rest of the oversized chunk so it isn't read as a command.
+		if data[n+1] != '\n' {
+			if _, err := mcReadLine(r); err != nil {
+				return err
+			}
+		}
+		w.WriteString(mcTextBadDataChunk)
+		return nil
+	}
+
+	_, res := s.mcStore(c, mode, k, data[:n], uint32(flags), exptime, cas)
+	switch res {
+	case mcStored:
+		mcReply(w, tokens, argsMin, mcTextStored)
+	case mcExists:
+		mcReply(w, tokens, argsMin, mcTextExists)
+	case mcNotFound:
+		mcReply(w, tokens, argsMin, mcTextNotFound)
+	default:
+		mcReply(w, tokens, argsMin, mcTextNotStored)
+	}
+	return nil
+}
+
+// mcReply writes res unless tokens[i] is noreply.
+func mcReply(w *bufio.Writer, tokens []string, i int, res string) {
+	if len(tokens) > i && tokens[i] == mcTextNoReply {
+		return
+	}
+	w.WriteString(res)
+}
+
+func mcReadLine(r *bufio.Reader) (string, error) {
+	var b []byte
+	for {
+		line, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		b = append(b, line...)
+		if !isPrefix {
+			return string(b), nil
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package memds
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metricsPath        = "/metrics"
+	metricsUnknownCmd  = "unknown"
+	metricsResultOK    = "ok"
+	metricsContentType = "text/plain; version=0.0.4"
+)
+
+// metricsLatencyBuckets are the upper bounds in seconds of the command
+// latency histogram.
+var metricsLatencyBuckets = []float64{
+	0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025,
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+type commandLabel struct {
+	cmd  string
+	code string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics counts what a Store and its Server do. It is rendered in the
+// prometheus text format.
+type Metrics struct {
+	// clients, connections, expired and evicted are updated with
+	// sync/atomic. Nothing evicts keys yet, so evicted stays 0.
+	clients     int64
+	connections uint64
+	expired     uint64
+	evicted     uint64
+
+	mu       sync.Mutex
+	commands map[commandLabel]uint64
+	latency  map[string]*histogram
+}
+
+func newMetrics() *Metrics {
+	m := Metrics{
+		commands: make(map[commandLabel]uint64),
+		latency:  make(map[string]*histogram),
+	}
+	return &m
+}
+
+func (m *Metrics) observeCommand(cmd string, res map[string]interface{}, d time.Duration) {
+	l := commandLabel{cmd: cmd, code: metricsResultOK}
+	if ok, _ := res["status"].(bool); !ok {
+		l.code = fmt.Sprintf("%v", res["code"])
+	}
+	sec := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commands[l]++
+
+	h, ok := m.latency[cmd]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(metricsLatencyBuckets))}
+		m.latency[cmd] = h
+	}
+	for i, le := range metricsLatencyBuckets {
+		if sec <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += sec
+}
+
+func (m *Metrics) clientConnected() {
+	atomic.AddInt64(&m.clients, 1)
+	atomic.AddUint64(&m.connections, 1)
+}
+
+func (m *Metrics) clientDisconnected() {
+	atomic.AddInt64(&m.clients, -1)
+}
+
+func (m *Metrics) keysExpired(n int) {
+	atomic.AddUint64(&m.expired, uint64(n))
+}
+
+// metricsCommandName returns the cmd label of the command c, unknown commands
+// share one label so clients can't grow the series without bound.
+func metricsCommandName(c *commandSpec) string {
+	if c == nil {
+		return metricsUnknownCmd
+	}
+	return c.name
+}
+
+// WriteMetrics writes the store metrics in the prometheus text format.
+func (s *Store) WriteMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	m := s.metrics
+
+	m.mu.Lock()
+	labels := make([]commandLabel, 0, len(m.commands))
+	for l := range m.commands {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].cmd != labels[j].cmd {
+			return labels[i].cmd < labels[j].cmd
+		}
+		return labels[i].code < labels[j].code
+	})
+	fmt.Fprintln(w, "# HELP memds_commands_total Commands processed by cmd and result code.")
+	fmt.Fprintln(w, "# TYPE memds_commands_total counter")
+	for _, l := range labels {
+		fmt.Fprintf(w, "memds_commands_total{cmd=%q,code=%q} %d\n", l.cmd, l.code, m.commands[l])
+	}
+
+	cmds := make([]string, 0, len(m.latency))
+	for c := range m.latency {
+		cmds = append(cmds, c)
+	}
+	sort.Strings(cmds)
+	fmt.Fprintln(w, "# HELP memds_command_duration_seconds Command latency.")
+	fmt.Fprintln(w, "# TYPE memds_command_duration_seconds histogram")
+	for _, c := range cmds {
+		h := m.latency[c]
+		for i, le := range metricsLatencyBuckets {
+			fmt.Fprintf(w, "memds_command_duration_seconds_bucket{cmd=%q,le=\"%g\"} %d\n", c, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "memds_command_duration_seconds_bucket{cmd=%q,le=\"+Inf\"} %d\n", c, h.count)
+		fmt.Fprintf(w, "memds_command_duration_seconds_sum{cmd=%q} %g\n", c, h.sum)
+		fmt.Fprintf(w, "memds_command_duration_seconds_count{cmd=%q} %d\n", c, h.count)
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP memds_connected_clients Open client connections.")
+	fmt.Fprintln(w, "# TYPE memds_connected_clients gauge")
+	fmt.Fprintf(w, "memds_connected_clients %d\n", atomic.LoadInt64(&m.clients))
+	fmt.Fprintln(w, "# HELP memds_connections_total Accepted client connections.")
+	fmt.Fprintln(w, "# TYPE memds_connections_total counter")
+	fmt.Fprintf(w, "memds_connections_total %d\n", atomic.LoadUint64(&m.connections))
+	fmt.Fprintln(w, "# HELP memds_expired_keys_total Keys removed because they expired.")
+	fmt.Fprintln(w, "# TYPE memds_expired_keys_total counter")
+	fmt.Fprintf(w, "memds_expired_keys_total %d\n", atomic.LoadUint64(&m.expired))
+	fmt.Fprintln(w, "# HELP memds_evicted_keys_total Keys removed to free memory.")
+	fmt.Fprintln(w, "# TYPE memds_evicted_keys_total counter")
+	fmt.Fprintf(w, "memds_evicted_keys_total %d\n", atomic.LoadUint64(&m.evicted))
+
+	buckets := s.statsBuckets()
+	keys := make([]int, 0, len(buckets))
+	size := make([]int64, 0, len(buckets))
+	for _, b := range buckets {
+		k, n := b.Stats()
+		keys = append(keys, k)
+		size = append(size, n)
+	}
+	fmt.Fprintln(w, "# HELP memds_bucket_keys Keys held by each bucket.")
+	fmt.Fprintln(w, "# TYPE memds_bucket_keys gauge")
+	for i, k := range keys {
+		fmt.Fprintf(w, "memds_bucket_keys{bucket=\"%d\"} %d\n", i, k)
+	}
+	fmt.Fprintln(w, "# HELP memds_bucket_bytes Bytes of keys, values and typed values held by each bucket.")
+	fmt.Fprintln(w, "# TYPE memds_bucket_bytes gauge")
+	for i, n := range size {
+		fmt.Fprintf(w, "memds_bucket_bytes{bucket=\"%d\"} %d\n", i, n)
+	}
+
+	return w.Flush()
+}
+
+func (s *Server) startMetrics() error {
+	if s.config.MetricsPort == 0 {
+		return nil
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.MetricsPort))
+	if err != nil {
+		return err
+	}
+	s.metricsListener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		if err := s.store.WriteMetrics(w); err != nil {
+			Error(fmt.Sprintf("metrics write error: %v", err))
+		}
+	})
+
+	go func() {
+		err := http.Serve(l, mux)
+		if err != nil && !s.isDraining() {
+			Error(fmt.Sprintf("metrics serve error: %v", err))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,68 @@
+package memds
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	s.execute(map[string]interface{}{"cmd": "set", "key": "key", "value": "value"}, new(client))
+	s.execute(map[string]interface{}{"cmd": "get", "key": "key"}, new(client))
+	s.execute(map[string]interface{}{"cmd": "get"}, new(client))
+	s.execute(map[string]interface{}{"cmd": "hoge"}, new(client))
+	s.metrics.clientConnected()
+
+	var b bytes.Buffer
+	if err := s.WriteMetrics(&b); err != nil {
+		t.Fatalf("got: %v, want: nil", err)
+	}
+	out := b.String()
+
+	for _, l := range []string{
+		`memds_commands_total{cmd="set",code="ok"} 1`,
+		`memds_commands_total{cmd="get",code="ok"} 1`,
+		`memds_commands_total{cmd="get",code="200"} 1`,
+		`memds_commands_total{cmd="unknown",code="300"} 1`,
+		`memds_command_duration_seconds_count{cmd="get"} 2`,
+		`memds_connected_clients 1`,
+		`memds_expired_keys_total 0`,
+		`memds_evicted_keys_total 0`,
+	} {
+		if !strings.Contains(out, l+"\n") {
+			t.Errorf("%q not found in:\n%s", l, out)
+		}
+	}
+
+	if strings.Count(out, "memds_bucket_keys{") != 2 {
+		t.Errorf("got: %v, want: 2 memds_bucket_keys", strings.Count(out, "memds_bucket_keys{"))
+	}
+}
+
+func TestBucketStatsObjects(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 1})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	tests := []map[string]interface{}{
+		{"cmd": "rpush", "key": "l", "values": []interface{}{"aaaa", "bbbb"}},
+		{"cmd": "zadd", "key": "z", "members": map[string]interface{}{"m": 1}},
+		{"cmd": "xadd", "key": "x", "id": "*", "fields": map[string]interface{}{"f": "vvvv"}},
+		{"cmd": "bf.add", "key": "bf", "item": "a"},
+		{"cmd": "cf.add", "key": "cf", "item": "a"},
+	}
+	_, last := s.buckets[0].Stats()
+	for _, cmd := range tests {
+		if res := exec(cmd); res["status"] != true {
+			t.Fatalf("%v got: %v", cmd, res)
+		}
+		_, n := s.buckets[0].Stats()
+		if n <= last {
+			t.Errorf("%v got: %v bytes, want: above %v", cmd, n, last)
+		}
+		last = n
+	}
+}
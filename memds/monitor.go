@@ -0,0 +1,114 @@
+package memds
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// monitorBufferSize is how many events a monitor may fall behind before
+// events for it are dropped.
+const monitorBufferSize = 1024
+
+type monitor struct {
+	events chan []byte
+	// dropped counts events skipped because the monitor was too slow.
+	dropped uint64
+}
+
+// monitors fans out every executed command to the subscribed monitors
+// without blocking on any of them.
+type monitors struct {
+	// n is the number of subscribers, read with sync/atomic so publish can
+	// skip encoding when there are none.
+	n    int32
+	mu   sync.RWMutex
+	subs map[*monitor]struct{}
+}
+
+func (ms *monitors) subscribe() *monitor {
+	m := monitor{
+		events: make(chan []byte, monitorBufferSize),
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.subs == nil {
+		ms.subs = make(map[*monitor]struct{})
+	}
+	ms.subs[&m] = struct{}{}
+	atomic.AddInt32(&ms.n, 1)
+	return &m
+}
+
+func (ms *monitors) unsubscribe(m *monitor) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.subs[m]; ok {
+		delete(ms.subs, m)
+		atomic.AddInt32(&ms.n, -1)
+	}
+}
+
+func (ms *monitors) active() bool {
+	return atomic.LoadInt32(&ms.n) > 0
+}
+
+func (ms *monitors) publish(ev []byte) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for m := range ms.subs {
+		select {
+		case m.events <- ev:
+		default:
+			atomic.AddUint64(&m.dropped, 1)
+		}
+	}
+}
+
+// publishMonitor sends cmd to every monitor as an encoded line.
+func (s *Store) publishMonitor(cmd map[string]interface{}, t time.Time, c *client) {
+	if !s.monitors.active() {
+		return
+	}
+
+	if v, ok := cmd["value"].(rawValue); ok {
+		c := make(map[string]interface{}, len(cmd))
+		for k, e := range cmd {
+			c[k] = e
+		}
+		c["value"], _ = v.decode(s.mh)
+		cmd = c
+	}
+
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, s.mh)
+	err := enc.Encode(
+		map[string]interface{}{
+			"time": t.UnixNano() / int64(time.Microsecond),
+			"addr": c.addr,
+			"cmd":  cmd,
+		},
+	)
+	if err != nil {
+		Error(err.Error())
+		return
+	}
+	b = append(b, '\n')
+	s.monitors.publish(b)
+}
+
+func (s *Store) execMonitor(c *client) map[string]interface{} {
+	if !c.streams {
+		return s.responseCmdExecuteError("monitor not supported on this connection")
+	}
+	if c.monitor == nil {
+		c.monitor = s.monitors.subscribe()
+	}
+	return s.responseOK()
+}
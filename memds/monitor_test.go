@@ -0,0 +1,61 @@
+package memds
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestMonitor(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	res := s.execute(map[string]interface{}{"cmd": "monitor"}, new(client))
+	if res["status"] != false {
+		t.Errorf("got: %v, want: false when connection can't stream", res["status"])
+	}
+
+	c := &client{addr: "addr", streams: true}
+	res = s.execute(map[string]interface{}{"cmd": "monitor"}, c)
+	if res["status"] != true || c.monitor == nil {
+		t.Fatalf("got: %v, want: monitor subscribed", res)
+	}
+
+	s.execute(map[string]interface{}{"cmd": "get", "key": "key"}, &client{addr: "other"})
+
+	var ev []byte
+	select {
+	case ev = <-c.monitor.events:
+	default:
+		t.Fatal("event not published")
+	}
+
+	m := make(map[string]interface{})
+	dec := codec.NewDecoderBytes(ev, s.mh)
+	if err := dec.Decode(&m); err != nil {
+		t.Fatalf("event decode error: %v", err)
+	}
+	if !reflect.DeepEqual(m["addr"], []byte("other")) {
+		t.Errorf("got: %v, want: other", m["addr"])
+	}
+
+	s.monitors.unsubscribe(c.monitor)
+	if s.monitors.active() {
+		t.Error("should not be active after unsubscribe")
+	}
+}
+
+func TestMonitorsDropSlow(t *testing.T) {
+	var ms monitors
+	m := ms.subscribe()
+
+	for i := 0; i < monitorBufferSize+10; i++ {
+		ms.publish([]byte("ev"))
+	}
+	if len(m.events) != monitorBufferSize {
+		t.Errorf("got: %v, want: %v", len(m.events), monitorBufferSize)
+	}
+	if m.dropped != 10 {
+		t.Errorf("got: %v, want: 10", m.dropped)
+	}
+}
@@ -0,0 +1,106 @@
+package memds
+
+import "time"
+
+// sizer is implemented by typed values to report roughly how many bytes
+// they hold.
+type sizer interface {
+	size() int64
+}
+
+// valueSize returns roughly how many bytes a decoded value holds.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case []interface{}:
+		var n int64
+		for _, e := range v {
+			n += valueSize(e)
+		}
+		return n
+	case map[string]interface{}:
+		var n int64
+		for k, e := range v {
+			n += int64(len(k)) + valueSize(e)
+		}
+		return n
+	}
+	return 8
+}
+
+// object returns the typed value of k, like a stream, treating expired keys
+// as missing. The caller must hold b.mu.
+func (b *Bucket) object(k string, now time.Time) (interface{}, bool) {
+	o, ok := b.objects[k]
+	if !ok || b.meta[k].expired(now) {
+		return nil, false
+	}
+	return o, true
+}
+
+// setObject stores the typed value o under k, replacing any value. The
+// caller must hold b.mu for writing.
+func (b *Bucket) setObject(k string, o interface{}) {
+	if _, ok := b.value[k]; ok {
+		b.remove(k)
+	}
+	if b.objects == nil {
+		b.objects = make(map[string]interface{})
+	}
+	b.objects[k] = o
+}
+
+// typed returns the typed value of k if it is not a plain value. If k
+// doesn't exist and create is set, the value made by create is stored. It
+// returns nil if k doesn't exist and create is nil. The caller must hold
+// b.mu, for writing if create is set.
+func (b *Bucket) typed(k string, create func() interface{}) (interface{}, error) {
+	now := time.Now()
+	if o, ok := b.object(k, now); ok {
+		return o, nil
+	}
+	if _, _, ok := b.lookup(k, now); ok {
+		return nil, WrongTypeError
+	}
+	if create == nil {
+		return nil, nil
+	}
+	// Drop what an expired key left behind.
+	b.remove(k)
+	o := create()
+	b.setObject(k, o)
+	return o, nil
+}
+
+// update runs fn with the bucket of k locked for writing.
+func (s *Store) update(k string, fn func(b *Bucket) error) error {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return BucketNotFoundError
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return fn(b)
+}
+
+// view runs fn with the bucket of k locked for reading.
+func (s *Store) view(k string, fn func(b *Bucket) error) error {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return BucketNotFoundError
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return fn(b)
+}
@@ -0,0 +1,228 @@
+package memds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+var msgpackFormatError = errors.New("msgpack format error")
+
+// msgpackValueKey is "value" encoded as a msgpack fixstr.
+var msgpackValueKey = []byte{0xa5, 'v', 'a', 'l', 'u', 'e'}
+
+// rawValue is a value kept as the msgpack bytes a client sent. In raw mode
+// set stores them as is and get writes them back without a codec pass.
+type rawValue []byte
+
+func (v rawValue) decode(h *codec.MsgpackHandle) (interface{}, error) {
+	var r interface{}
+	dec := codec.NewDecoderBytes(v, h)
+	if err := dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// decodeRawCommand decodes the command b like exec does, but keeps the
+// msgpack bytes of its value as a rawValue.
+func (s *Store) decodeRawCommand(b []byte) (map[string]interface{}, error) {
+	n, i, err := msgpackMapLen(b)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := make(map[string]interface{}, n)
+	for ; n > 0; n-- {
+		ks := i
+		if i, err = msgpackSkip(b, i); err != nil {
+			return nil, err
+		}
+		vs := i
+		if i, err = msgpackSkip(b, i); err != nil {
+			return nil, err
+		}
+
+		name, ok := msgpackString(b[ks:vs])
+		if !ok {
+			var k interface{}
+			if err := codec.NewDecoderBytes(b[ks:vs], s.mh).Decode(&k); err != nil {
+				return nil, err
+			}
+			name = fmt.Sprintf("%v", k)
+		}
+
+		if name == "value" {
+			// b is reused for the next command.
+			cmd[name] = rawValue(append([]byte(nil), b[vs:i]...))
+			continue
+		}
+		if v, ok := msgpackString(b[vs:i]); ok {
+			cmd[name] = v
+			continue
+		}
+		var v interface{}
+		if err := codec.NewDecoderBytes(b[vs:i], s.mh).Decode(&v); err != nil {
+			return nil, err
+		}
+		cmd[name] = v
+	}
+	return cmd, nil
+}
+
+// encodeRawResponse encodes m, splicing in its rawValue value as is.
+func (s *Store) encodeRawResponse(m map[string]interface{}, v rawValue) ([]byte, error) {
+	rest := make(map[string]interface{}, len(m))
+	for k, e := range m {
+		if k != "value" {
+			rest[k] = e
+		}
+	}
+
+	var rb []byte
+	enc := codec.NewEncoderBytes(&rb, s.mh)
+	if err := enc.Encode(rest); err != nil {
+		return nil, err
+	}
+	// Responses hold a few entries, their header is a single fixmap byte.
+	if len(rb) == 0 || rb[0]&0xf0 != 0x80 || rb[0] == 0x8f {
+		return nil, msgpackFormatError
+	}
+	rb[0]++
+	rb = append(rb, msgpackValueKey...)
+	rb = append(rb, v...)
+	return rb, nil
+}
+
+// msgpackMapLen reads the map header at the start of b and returns the
+// number of entries and where the first one starts.
+func msgpackMapLen(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, msgpackFormatError
+	}
+	switch c := b[0]; {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), 1, nil
+	case c == 0xde && len(b) >= 3:
+		return int(binary.BigEndian.Uint16(b[1:])), 3, nil
+	case c == 0xdf && len(b) >= 5:
+		return int(binary.BigEndian.Uint32(b[1:])), 5, nil
+	default:
+		return 0, 0, msgpackFormatError
+	}
+}
+
+// msgpackSkip returns where the msgpack object starting at b[i] ends.
+func msgpackSkip(b []byte, i int) (int, error) {
+	for pending := 1; pending > 0; pending-- {
+		if i >= len(b) {
+			return 0, msgpackFormatError
+		}
+		c := b[i]
+		var head, size, items int
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+			head = 1
+		case c&0xf0 == 0x80:
+			head, items = 1, 2*int(c&0x0f)
+		case c&0xf0 == 0x90:
+			head, items = 1, int(c&0x0f)
+		case c&0xe0 == 0xa0:
+			head, size = 1, int(c&0x1f)
+		case c == 0xcc || c == 0xd0:
+			head = 2
+		case c == 0xcd || c == 0xd1:
+			head = 3
+		case c == 0xca || c == 0xce || c == 0xd2:
+			head = 5
+		case c == 0xcb || c == 0xcf || c == 0xd3:
+			head = 9
+		case c >= 0xd4 && c <= 0xd8:
+			head = 2 + 1<<(c-0xd4)
+		case c == 0xc4 || c == 0xd9:
+			head, size = 2, msgpackUint(b, i+1, 1)
+		case c == 0xc5 || c == 0xda:
+			head, size = 3, msgpackUint(b, i+1, 2)
+		case c == 0xc6 || c == 0xdb:
+			head, size = 5, msgpackUint(b, i+1, 4)
+		case c == 0xc7:
+			head, size = 3, msgpackUint(b, i+1, 1)
+		case c == 0xc8:
+			head, size = 4, msgpackUint(b, i+1, 2)
+		case c == 0xc9:
+			head, size = 6, msgpackUint(b, i+1, 4)
+		case c == 0xdc:
+			head, items = 3, msgpackUint(b, i+1, 2)
+		case c == 0xdd:
+			head, items = 5, msgpackUint(b, i+1, 4)
+		case c == 0xde:
+			head, items = 3, 2*msgpackUint(b, i+1, 2)
+		case c == 0xdf:
+			head, items = 5, 2*msgpackUint(b, i+1, 4)
+		default:
+			return 0, msgpackFormatError
+		}
+		if size < 0 || items < 0 || i+head+size > len(b) || items > len(b)-i-head {
+			return 0, msgpackFormatError
+		}
+		i += head + size
+		pending += items
+	}
+	return i, nil
+}
+
+// msgpackString returns b as a string if it is exactly one msgpack str or
+// bin object.
+func msgpackString(b []byte) (string, bool) {
+	p, ok := msgpackBytes(b)
+	if !ok {
+		return "", false
+	}
+	return string(p), true
+}
+
+// msgpackBytes is like msgpackString but returns the bytes of b, without a
+// copy.
+func msgpackBytes(b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	var head int
+	switch c := b[0]; {
+	case c&0xe0 == 0xa0:
+		head = 1
+	case c == 0xc4 || c == 0xd9:
+		head = 2
+	case c == 0xc5 || c == 0xda:
+		head = 3
+	case c == 0xc6 || c == 0xdb:
+		head = 5
+	default:
+		return nil, false
+	}
+	if head > len(b) {
+		return nil, false
+	}
+	if n, err := msgpackSkip(b, 0); err != nil || n != len(b) {
+		return nil, false
+	}
+	return b[head:], true
+}
+
+// msgpackUint reads an n byte big endian length at b[i], or returns -1 if b
+// is too short.
+func msgpackUint(b []byte, i, n int) int {
+	if i+n > len(b) {
+		return -1
+	}
+	var v uint64
+	for _, c := range b[i : i+n] {
+		v = v<<8 | uint64(c)
+	}
+	if v > uint64(len(b)) {
+		return -1
+	}
+	return int(v)
+}
@@ -0,0 +1,124 @@
+package memds
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+// encodeTest encodes v with map keys sorted, so the bytes of a value are
+// the same alone and inside a command.
+func encodeTest(t testing.TB, v interface{}) []byte {
+	h := newMsgpackHandle()
+	h.Canonical = true
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, h)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMsgpackSkip(t *testing.T) {
+	testCase := []interface{}{
+		nil,
+		true,
+		1,
+		-1,
+		300,
+		-40000,
+		1 << 40,
+		1.5,
+		"value",
+		strings.Repeat("v", 300),
+		[]byte(strings.Repeat("v", 70000)),
+		[]interface{}{1, "a", []interface{}{nil}},
+		map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": []interface{}{1, 2}}},
+	}
+	for _, tc := range testCase {
+		b := encodeTest(t, tc)
+		n, err := msgpackSkip(append(b, 0x01), 0)
+		if err != nil || n != len(b) {
+			t.Errorf("%v got: %v, %v, want: %v", tc, n, err, len(b))
+		}
+		if _, err := msgpackSkip(b[:len(b)-1], 0); err == nil {
+			t.Errorf("%v should be error when truncated", tc)
+		}
+	}
+}
+
+func TestRawValues(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2, RawValues: true})
+	value := map[string]interface{}{"name": "memds", "tags": []interface{}{"a", "b"}}
+	raw := encodeTest(t, value)
+
+	res := s.exec(encodeTest(t, map[string]interface{}{"cmd": "set", "key": "key", "value": value}), new(client))
+	if !bytes.Contains(res, encodeTest(t, "OK")) {
+		t.Errorf("got: %v", res)
+	}
+	b := s.bucket("key")
+	if !bytes.Equal(b.value["key"], raw) {
+		t.Errorf("got: %v, want: %v", b.value["key"], raw)
+	}
+
+	res = s.exec(encodeTest(t, map[string]interface{}{"cmd": "get", "key": "key"}), new(client))
+	if !bytes.Contains(res, raw) {
+		t.Errorf("got: %v, want value bytes: %v", res, raw)
+	}
+	var m map[string]interface{}
+	if err := codec.NewDecoderBytes(res, &mh).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m["status"] != true {
+		t.Errorf("got: %v", m)
+	}
+	v, _ := m["value"].(map[string]interface{})
+	if !reflect.DeepEqual(toJSON(v), map[string]interface{}{"name": "memds", "tags": []interface{}{"a", "b"}}) {
+		t.Errorf("got: %v", m["value"])
+	}
+
+	// Values stored raw read the same through the decoding paths.
+	got, err := s.Get("key")
+	if err != nil || !reflect.DeepEqual(toJSON(got), toJSON(v)) {
+		t.Errorf("got: %v, %v", got, err)
+	}
+
+	res = s.exec(encodeTest(t, map[string]interface{}{"cmd": "get", "key": "missing"}), new(client))
+	m = nil
+	codec.NewDecoderBytes(res, &mh).Decode(&m)
+	if m["status"] != true || m["value"] != nil {
+		t.Errorf("got: %v", m)
+	}
+
+	// Other commands reading a value get it decoded.
+	res = s.exec(encodeTest(t, map[string]interface{}{"cmd": "config", "sub": "set", "name": "max_clients", "value": "10"}), new(client))
+	m = nil
+	codec.NewDecoderBytes(res, &mh).Decode(&m)
+	if m["status"] != true || s.Config().MaxClients != 10 {
+		t.Errorf("got: %v, max_clients %v, want: 10", m, s.Config().MaxClients)
+	}
+}
+
+func benchmarkExec(b *testing.B, raw bool) {
+	s, _ := NewStore(&Config{BucketNum: 16, RawValues: raw})
+	value := map[string]interface{}{"name": "memds", "tags": []interface{}{"a", "b", "c"}}
+	set := encodeTest(b, map[string]interface{}{"cmd": "set", "key": "key", "value": value})
+	get := encodeTest(b, map[string]interface{}{"cmd": "get", "key": "key"})
+	c := new(client)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.exec(set, c)
+		s.exec(get, c)
+	}
+}
+
+func BenchmarkExecDecoded(b *testing.B) {
+	benchmarkExec(b, false)
+}
+
+func BenchmarkExecRaw(b *testing.B) {
+	benchmarkExec(b, true)
+}
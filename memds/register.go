@@ -0,0 +1,179 @@
+package memds
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CommandHandler runs a command added with RegisterCommand. cmd is the
+// decoded command, the map returned is sent back as the response and is
+// best made with the Response helpers of s.
+type CommandHandler func(s *Store, cmd map[string]interface{}) map[string]interface{}
+
+// CommandInfo describes a command added with RegisterCommandInfo.
+type CommandInfo struct {
+	// Required are the fields the command can't run without.
+	Required []string
+	// Keys are the fields naming the keys the command uses, a field is a key
+	// or a list of keys.
+	Keys []string
+	// ReadOnly commands don't change keys.
+	ReadOnly bool
+}
+
+// commands holds the commands added with RegisterCommand.
+type commands struct {
+	mu    sync.RWMutex
+	specs map[string]*commandSpec
+}
+
+func (cs *commands) get(name string) *commandSpec {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	return cs.specs[name]
+}
+
+func (cs *commands) names() []string {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	names := make([]string, 0, len(cs.specs))
+	for name := range cs.specs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// RegisterCommand adds the command name run by h. It is dispatched by Exec
+// and the servers like the built in commands, which it can't replace. The
+// command is taken to write the keys in its key, keys and dest fields.
+func (s *Store) RegisterCommand(name string, h CommandHandler) error {
+	return s.RegisterCommandInfo(name, CommandInfo{Keys: []string{"key", "keys", "dest"}}, h)
+}
+
+// RegisterCommandInfo is like RegisterCommand, with the fields and keys of
+// the command described by info.
+func (s *Store) RegisterCommandInfo(name string, info CommandInfo, h CommandHandler) error {
+	if _, ok := commandTable[name]; ok {
+		return fmt.Errorf("%v: %s", CommandExistsError, name)
+	}
+
+	s.commands.mu.Lock()
+	defer s.commands.mu.Unlock()
+
+	if _, ok := s.commands.specs[name]; ok {
+		return fmt.Errorf("%v: %s", CommandExistsError, name)
+	}
+	if s.commands.specs == nil {
+		s.commands.specs = make(map[string]*commandSpec)
+	}
+	spec := &commandSpec{
+		name:     name,
+		required: info.Required,
+		keys:     info.Keys,
+		run: func(s *Store, name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+			if _, ok := cmd["value"].(rawValue); ok {
+				v, errRes := s.valueArg(cmd)
+				if errRes != nil {
+					return errRes
+				}
+				cmd["value"] = v
+			}
+			return h(s, cmd)
+		},
+	}
+	if !info.ReadOnly {
+		spec.flags = cmdWrite
+	}
+	s.commands.specs[name] = spec
+	return nil
+}
+
+func RegisterCommand(name string, h CommandHandler) error {
+	return defaultStore.RegisterCommand(name, h)
+}
+
+func RegisterCommandInfo(name string, info CommandInfo, h CommandHandler) error {
+	return defaultStore.RegisterCommandInfo(name, info, h)
+}
+
+// LockedBucket is the bucket of a key, locked while the function given to
+// Store.View or Store.Update runs. Other keys of the bucket, like keys with
+// the same {tag}, can be used too.
+type LockedBucket struct {
+	s     *Store
+	b     *Bucket
+	write bool
+}
+
+func (l *LockedBucket) check(k string, write bool) error {
+	if write && !l.write {
+		return ReadOnlyBucketError
+	}
+	if l.s.bucket(k) != l.b {
+		return KeyNotInBucketError
+	}
+	return nil
+}
+
+// Get returns the value of k, or ValueNotFoundError.
+func (l *LockedBucket) Get(k string) (interface{}, error) {
+	if err := l.check(k, false); err != nil {
+		return nil, err
+	}
+	return l.b.get(k)
+}
+
+// Set stores v under k. It fails unless the bucket was locked by Update.
+func (l *LockedBucket) Set(k string, v interface{}) error {
+	if err := l.check(k, true); err != nil {
+		return err
+	}
+	return l.b.set(k, v)
+}
+
+// Del deletes k and reports whether it existed. It fails unless the bucket
+// was locked by Update.
+func (l *LockedBucket) Del(k string) (bool, error) {
+	if err := l.check(k, true); err != nil {
+		return false, err
+	}
+	return l.b.remove(k), nil
+}
+
+// View runs fn with the bucket of k locked for reading.
+func (s *Store) View(k string, fn func(b *LockedBucket) error) error {
+	return s.view(k, func(b *Bucket) error {
+		return fn(&LockedBucket{s: s, b: b})
+	})
+}
+
+// Update runs fn with the bucket of k locked for writing, so reading and
+// changing keys of the bucket is atomic.
+func (s *Store) Update(k string, fn func(b *LockedBucket) error) error {
+	return s.update(k, func(b *Bucket) error {
+		return fn(&LockedBucket{s: s, b: b, write: true})
+	})
+}
+
+// Response returns m as a successful response, unless m has a status.
+func (s *Store) Response(m map[string]interface{}) map[string]interface{} {
+	return s.response(m)
+}
+
+// ResponseOK returns a successful response without a value.
+func (s *Store) ResponseOK() map[string]interface{} {
+	return s.responseOK()
+}
+
+// ResponseFormatError returns the response to a command with missing or
+// mistyped fields.
+func (s *Store) ResponseFormatError(msg string) map[string]interface{} {
+	return s.responseCmdFormatError(msg)
+}
+
+// ResponseExecuteError returns the response to a command that failed.
+func (s *Store) ResponseExecuteError(msg string) map[string]interface{} {
+	return s.responseCmdExecuteError(msg)
+}
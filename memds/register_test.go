@@ -0,0 +1,115 @@
+package memds
+
+import (
+	"fmt"
+	"testing"
+)
+
+// incr is a command adding 1 to the int value of "key".
+func incr(s *Store, cmd map[string]interface{}) map[string]interface{} {
+	k, ok := cmd["key"].(string)
+	if !ok {
+		return s.ResponseFormatError("key 'key' not type string")
+	}
+	var n int64
+	err := s.Update(k, func(b *LockedBucket) error {
+		v, err := b.Get(k)
+		if err != nil && err != ValueNotFoundError {
+			return err
+		}
+		n, _ = v.(int64)
+		n++
+		return b.Set(k, n)
+	})
+	if err != nil {
+		return s.ResponseExecuteError(err.Error())
+	}
+	return s.Response(map[string]interface{}{"value": n})
+}
+
+func TestRegisterCommand(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	if err := s.RegisterCommand("incr", incr); err != nil {
+		t.Fatalf("got: %v, want: nil", err)
+	}
+	for _, name := range []string{"incr", "get"} {
+		if err := s.RegisterCommand(name, incr); err == nil {
+			t.Errorf("%s got: nil, want: %v", name, CommandExistsError)
+		}
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		res := exec(map[string]interface{}{"cmd": "incr", "key": "n"})
+		if res["value"] != i {
+			t.Errorf("got: %v, want: %v", res, i)
+		}
+	}
+	res := exec(map[string]interface{}{"cmd": "incr"})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "eval", "script": `return memds.call({cmd = "incr", key = KEYS[1]})`, "keys": "n"})
+	if res["value"] != int64(4) {
+		t.Errorf("got: %v, want: 4", res)
+	}
+
+	other, _ := NewStore(&Config{BucketNum: 8})
+	res = other.execute(map[string]interface{}{"cmd": "incr", "key": "n"}, new(client))
+	if res["code"] != ErrorCodeCommandNotFoundError {
+		t.Errorf("got: %v, want: command not found", res)
+	}
+}
+
+func TestRegisterCommandRawValues(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8, RawValues: true})
+	var got interface{}
+	s.RegisterCommand("echo", func(s *Store, cmd map[string]interface{}) map[string]interface{} {
+		got = cmd["value"]
+		return s.ResponseOK()
+	})
+
+	s.exec(encodeTest(t, map[string]interface{}{"cmd": "echo", "value": int64(7)}), new(client))
+	if got != int64(7) {
+		t.Errorf("got: %#v, want: 7", got)
+	}
+}
+
+func TestLockedBucket(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 8})
+	s.Set("{user}:name", "a")
+
+	err := s.View("{user}:id", func(b *LockedBucket) error {
+		if v, err := b.Get("{user}:name"); err != nil || v == nil {
+			t.Errorf("got: %v %v, want: a", v, err)
+		}
+		return b.Set("{user}:name", "b")
+	})
+	if err != ReadOnlyBucketError {
+		t.Errorf("got: %v, want: %v", err, ReadOnlyBucketError)
+	}
+
+	// Find a key of another bucket.
+	var k string
+	for i := 0; ; i++ {
+		k = fmt.Sprintf("key%d", i)
+		if s.bucket(k) != s.bucket("{user}") {
+			break
+		}
+	}
+	err = s.Update("{user}:id", func(b *LockedBucket) error {
+		if ok, err := b.Del("{user}:name"); !ok || err != nil {
+			t.Errorf("got: %v %v, want: true", ok, err)
+		}
+		return b.Set(k, 1)
+	})
+	if err != KeyNotInBucketError {
+		t.Errorf("got: %v, want: %v", err, KeyNotInBucketError)
+	}
+	if s.exists("{user}:name") {
+		t.Error("got: exists, want: deleted")
+	}
+}
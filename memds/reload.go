@@ -0,0 +1,151 @@
+package memds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ReloadConfig re-reads the config file and applies the settings that can
+// change without a restart.
+func (s *Store) ReloadConfig() error {
+	file := s.Config().file
+	if file == "" {
+		return ConfigFileNotSetError
+	}
+	c, err := LoadConfig(file)
+	if err != nil {
+		return err
+	}
+	return s.applyConfig(c)
+}
+
+// applyConfig replaces the store config with c. Settings that need a
+// restart keep their running value and are logged.
+func (s *Store) applyConfig(c *Config) error {
+	return s.updateConfig(func(nc *Config) error {
+		file := nc.file
+		*nc = *c
+		nc.file = file
+		return nil
+	})
+}
+
+// updateConfig replaces the store config with a copy changed by fn like
+// applyConfig does. cmu is held from the copy to the replace, so concurrent
+// updates are not lost.
+func (s *Store) updateConfig(fn func(c *Config) error) error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	old := s.config
+	nc := *old
+	if err := fn(&nc); err != nil {
+		return err
+	}
+	if err := SetLogLevel(nc.LogLevel); err != nil {
+		return err
+	}
+
+	var changed, restart []string
+	for _, name := range nc.Diff(old) {
+		if reloadableConfig[name] {
+			changed = append(changed, name)
+		} else {
+			restart = append(restart, name)
+			nc.copyField(name, old)
+		}
+	}
+	if len(changed) > 0 {
+		Info(fmt.Sprintf("config changed: %s", strings.Join(changed, ", ")))
+	}
+	if len(restart) > 0 {
+		Warn(fmt.Sprintf("config needs restart: %s", strings.Join(restart, ", ")))
+	}
+
+	s.config = &nc
+	return nil
+}
+
+// RewriteConfig writes the running config to the file it was loaded from.
+func (s *Store) RewriteConfig() error {
+	c := s.Config()
+	if c.file == "" {
+		return ConfigFileNotSetError
+	}
+
+	fi, err := os.Stat(c.file)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.file), filepath.Base(c.file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// The temp file is 0600, keep the mode of the file it replaces.
+	if err := f.Chmod(fi.Mode()); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := toml.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.file)
+}
+
+func (s *Store) execConfig(cmd map[string]interface{}) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	switch sub {
+	case "get":
+		name, errRes := s.optionalStringArg(cmd, "name")
+		if errRes != nil {
+			return errRes
+		}
+		return s.response(map[string]interface{}{"config": s.Config().Map(name)})
+	case "set":
+		name, errRes := s.stringArg(cmd, "name")
+		if errRes != nil {
+			return errRes
+		}
+		v, errRes := s.valueArg(cmd)
+		if errRes != nil {
+			return errRes
+		}
+		value := fmt.Sprintf("%v", v)
+		if b, ok := v.([]uint8); ok {
+			value = Uint8ArrayToString(b)
+		}
+		if !reloadableConfig[name] {
+			return s.responseCmdExecuteError(fmt.Sprintf("config %s needs restart", name))
+		}
+
+		err := s.updateConfig(func(c *Config) error {
+			return c.Set(name, value)
+		})
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.responseOK()
+	case "rewrite":
+		if err := s.RewriteConfig(); err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.responseOK()
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
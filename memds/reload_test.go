@@ -0,0 +1,117 @@
+package memds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigSet(t *testing.T) {
+	s, _ := NewStore(&Config{Port: 6700, BucketNum: 2})
+	defer SetLogLevel("")
+
+	testCase := []struct {
+		Name   string
+		Value  interface{}
+		Status bool
+	}{
+		{Name: "max_clients", Value: 10, Status: true},
+		{Name: "idle_timeout", Value: "5s", Status: true},
+		{Name: "log_level", Value: "warn", Status: true},
+		{Name: "log_level", Value: "loud", Status: false},
+		{Name: "idle_timeout", Value: "soon", Status: false},
+		{Name: "port", Value: 6701, Status: false},
+		{Name: "unknown", Value: 1, Status: false},
+	}
+	for _, tc := range testCase {
+		res := s.execConfig(map[string]interface{}{"sub": "set", "name": tc.Name, "value": tc.Value})
+		if res["status"] != tc.Status {
+			t.Errorf("%s %v got: %v, want: %v", tc.Name, tc.Value, res["status"], tc.Status)
+		}
+	}
+
+	c := s.Config()
+	if c.MaxClients != 10 || c.IdleTimeout.Duration != 5*time.Second || c.Port != 6700 {
+		t.Errorf("got: %v", c.Map(""))
+	}
+}
+
+func TestConfigSetConcurrent(t *testing.T) {
+	s, _ := NewStore(&Config{Port: 6700, BucketNum: 2})
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"max_clients", "slowlog_max_len"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 1; i <= 200; i++ {
+				s.execConfig(map[string]interface{}{"sub": "set", "name": name, "value": i})
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	// Neither goroutine's last set is lost.
+	c := s.Config()
+	if c.MaxClients != 200 || c.SlowlogMaxLen != 200 {
+		t.Errorf("got: %v, %v, want: 200, 200", c.MaxClients, c.SlowlogMaxLen)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetLogLevel("")
+
+	p := filepath.Join(dir, "memds.toml")
+	if err := ioutil.WriteFile(p, []byte("port = 6700\nbucket_num = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := NewStore(c)
+
+	data := "port = 6701\nbucket_num = 2\nmax_clients = 3\nslowlog_threshold = \"10ms\"\n"
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	c = s.Config()
+	if c.Port != 6700 || c.MaxClients != 3 || c.SlowlogThreshold.Duration != 10*time.Millisecond {
+		t.Errorf("got: %v", c.Map(""))
+	}
+
+	s.execConfig(map[string]interface{}{"sub": "set", "name": "max_clients", "value": 5})
+	if res := s.execConfig(map[string]interface{}{"sub": "rewrite"}); res["status"] != true {
+		t.Fatalf("got: %v", res)
+	}
+	c, err = LoadConfig(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 6700 || c.MaxClients != 5 || c.SlowlogThreshold.Duration != 10*time.Millisecond {
+		t.Errorf("got: %v", c.Map(""))
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("got: %v, want: %v", fi.Mode().Perm(), os.FileMode(0644))
+	}
+
+	s, _ = NewStore(&Config{BucketNum: 2})
+	if err := s.ReloadConfig(); err != ConfigFileNotSetError {
+		t.Errorf("got: %v, want: %v", err, ConfigFileNotSetError)
+	}
+}
@@ -0,0 +1,187 @@
+package memds
+
+import "fmt"
+
+// migrateBatch is the number of keys migrateStep moves at a time.
+const migrateBatch = 128
+
+// Resize changes the number of buckets. Keys are moved to their new bucket
+// a batch at a time in the background, both layouts serve commands until the
+// move is done.
+func (s *Store) Resize(n int) error {
+	b, err := s.newBuckets(n)
+	if err != nil {
+		return err
+	}
+
+	s.bmu.Lock()
+	if s.old != nil {
+		s.bmu.Unlock()
+		return ResizeInProgressError
+	}
+	s.old = s.buckets
+	s.buckets = b
+	s.moved = 0
+	s.pending = nil
+	s.bmu.Unlock()
+
+	s.cmu.Lock()
+	c := *s.config
+	c.BucketNum = n
+	s.config = &c
+	s.cmu.Unlock()
+
+	Info(fmt.Sprintf("resize buckets to %d", n))
+	go func() {
+		for s.migrateStep() {
+		}
+		Info(fmt.Sprintf("resize buckets to %d done", n))
+	}()
+	return nil
+}
+
+// Resizing reports whether keys are still being moved by Resize.
+func (s *Store) Resizing() bool {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	return s.old != nil
+}
+
+// migrateStep moves up to migrateBatch keys of the next old bucket to their
+// new bucket and reports whether any key is left. s.bmu is held only for one
+// batch, so commands run between batches.
+func (s *Store) migrateStep() bool {
+	s.bmu.Lock()
+	defer s.bmu.Unlock()
+
+	if s.moved < len(s.old) {
+		ob := s.old[s.moved]
+		ob.mu.Lock()
+		if s.pending == nil {
+			s.pending = make(map[string]struct{}, len(ob.value)+len(ob.objects))
+			for k := range ob.value {
+				s.pending[k] = struct{}{}
+			}
+			for k := range ob.objects {
+				s.pending[k] = struct{}{}
+			}
+		}
+		n := 0
+		for k := range s.pending {
+			if n == migrateBatch {
+				break
+			}
+			s.migrateKey(ob, k)
+			delete(s.pending, k)
+			n++
+		}
+		ob.mu.Unlock()
+		if len(s.pending) == 0 {
+			s.moved++
+			s.pending = nil
+		}
+	}
+
+	if s.moved < len(s.old) {
+		return true
+	}
+	s.old = nil
+	s.moved = 0
+	return false
+}
+
+// migrateKey moves k, if it still exists, from ob to its new bucket. The
+// caller must hold s.bmu and ob.mu for writing.
+func (s *Store) migrateKey(ob *Bucket, k string) {
+	v, isValue := ob.value[k]
+	o, isObject := ob.objects[k]
+	m := ob.meta[k]
+	// Removing first keeps indexes shared by both buckets right.
+	if !ob.remove(k) {
+		return
+	}
+
+	nb := s.buckets.get(s.hasher(), k)
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+
+	if isValue {
+		nb.put(k, v)
+	} else if isObject {
+		nb.setObject(k, o)
+	}
+	if m != nil {
+		nb.meta[k] = m
+	}
+	// Keep cas values unique for keys that moved.
+	if nb.casSeq < ob.casSeq {
+		nb.casSeq = ob.casSeq
+	}
+}
+
+// bucket returns the bucket holding k. Keys of old buckets not moved yet are
+// still served by them, new keys of the bucket being moved go to the new
+// layout. The caller must hold s.bmu.
+func (s *Store) bucket(k string) *Bucket {
+	if len(s.old) > 0 {
+		i := s.old.index(s.hasher(), k)
+		if i > s.moved {
+			return s.old[i]
+		}
+		if i == s.moved {
+			if _, ok := s.pending[k]; ok || s.pending == nil {
+				return s.old[i]
+			}
+		}
+	}
+	return s.buckets.get(s.hasher(), k)
+}
+
+// hasher returns the hash picking buckets, falling back to crc32 for stores
+// built without one.
+func (s *Store) hasher() Hash {
+	if s.hash == nil {
+		return crc32Hash{}
+	}
+	return s.hash
+}
+
+// statsBuckets returns every bucket holding keys, for reporting.
+func (s *Store) statsBuckets() Buckets {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	return s.allBuckets()
+}
+
+// allBuckets returns every bucket holding keys. The caller must hold s.bmu.
+func (s *Store) allBuckets() Buckets {
+	if len(s.old) == 0 {
+		return s.buckets
+	}
+	b := make(Buckets, 0, len(s.buckets)+len(s.old)-s.moved)
+	b = append(b, s.buckets...)
+	return append(b, s.old[s.moved:]...)
+}
+
+func (s *Store) execResize(cmd map[string]interface{}) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	if sub != "buckets" {
+		return s.responseCmdNotFoundError()
+	}
+	if _, ok := cmd["count"]; !ok {
+		return s.responseCmdFormatError("key 'count' not found")
+	}
+	n, errRes := s.optionalIntArg(cmd, "count", 0)
+	if errRes != nil {
+		return errRes
+	}
+	if err := s.Resize(int(n)); err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.responseOK()
+}
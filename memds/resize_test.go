@@ -0,0 +1,118 @@
+package memds
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResize(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 4})
+	for i := 0; i < 100; i++ {
+		k := strconv.Itoa(i)
+		s.Set(k, []byte(k))
+	}
+	s.mcStore(new(client), mcModeSet, "mc", []byte("v"), 7, 0, 0)
+
+	// Move the keys by hand to check both layouts serve them.
+	s.old = s.buckets
+	s.buckets, _ = s.newBuckets(7)
+	for step := 0; ; step++ {
+		for i := 0; i < 100; i++ {
+			k := strconv.Itoa(i)
+			v, err := s.Get(k)
+			if err != nil || !reflect.DeepEqual(v, []byte(k)) {
+				t.Fatalf("step: %v, key: %v, got: %v, %v", step, k, v, err)
+			}
+		}
+		if it, ok := s.mcGet(new(client), "mc"); !ok || it.flags != 7 {
+			t.Fatalf("step: %v, got: %v, %v", step, it, ok)
+		}
+		s.Set(strconv.Itoa(step), []byte(strconv.Itoa(step)))
+		if !s.migrateStep() {
+			break
+		}
+	}
+	if s.old != nil {
+		t.Errorf("got: %v, want: nil", s.old)
+	}
+	n := 0
+	for _, b := range s.buckets {
+		keys, _ := b.Stats()
+		n += keys
+	}
+	if n != 101 {
+		t.Errorf("got: %v, want: 101", n)
+	}
+
+	if err := s.Resize(0); err != BucketsLEZeroError {
+		t.Errorf("got: %v, want: %v", err, BucketsLEZeroError)
+	}
+	res := s.execResize(map[string]interface{}{"sub": "buckets", "count": 2})
+	if res["status"] != true {
+		t.Fatalf("got: %v", res)
+	}
+	for i := 0; s.Resizing(); i++ {
+		if i > 100 {
+			t.Fatal("resize not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.buckets.Len() != 2 || s.Config().BucketNum != 2 {
+		t.Errorf("got: %v, %v, want: 2", s.buckets.Len(), s.Config().BucketNum)
+	}
+	if v, err := s.Get("99"); err != nil || !reflect.DeepEqual(v, []byte("99")) {
+		t.Errorf("got: %v, %v", v, err)
+	}
+}
+
+func TestResizeBatches(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 1})
+	n := migrateBatch*3 + 1
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		s.Set(k, []byte(k))
+	}
+
+	old := s.buckets
+	s.old = old
+	s.buckets, _ = s.newBuckets(3)
+	steps := 0
+	for s.migrateStep() {
+		steps++
+		// Moved keys and keys not moved yet are each counted once.
+		keys := 0
+		for _, b := range s.statsBuckets() {
+			k, _ := b.Stats()
+			keys += k
+		}
+		if keys != n {
+			t.Fatalf("step: %v, got: %v keys, want: %v", steps, keys, n)
+		}
+		for i := 0; i < n; i++ {
+			k := strconv.Itoa(i)
+			if v, err := s.Get(k); err != nil || !reflect.DeepEqual(v, []byte(k)) {
+				t.Fatalf("step: %v, key: %v, got: %v, %v", steps, k, v, err)
+			}
+		}
+		// Keys deleted and set again between batches end up moved.
+		k := strconv.Itoa(steps)
+		s.Del(k)
+		s.Set(k, []byte(k))
+	}
+	if steps != 3 {
+		t.Errorf("got: %v steps, want: 3", steps)
+	}
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		if v, err := s.Get(k); err != nil || !reflect.DeepEqual(v, []byte(k)) {
+			t.Errorf("key: %v, got: %v, %v", k, v, err)
+		}
+	}
+	for _, b := range old {
+		if keys, _ := b.Stats(); keys != 0 {
+			t.Errorf("got: %v keys left in old bucket, want: 0", keys)
+		}
+	}
+}
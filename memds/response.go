@@ -6,12 +6,18 @@ import (
 	"github.com/ugorji/go/codec"
 )
 
-func response(m map[string]interface{}) []byte {
-	if _, ok := m["status"]; !ok {
-		m["status"] = true
+func (s *Store) encodeResponse(m map[string]interface{}) []byte {
+	if v, ok := m["value"].(rawValue); ok {
+		rb, err := s.encodeRawResponse(m, v)
+		if err == nil {
+			return append(rb, '\n')
+		}
+		Error(fmt.Sprintf("raw response encode error: %v", err))
+		m["value"], _ = v.decode(s.mh)
 	}
+
 	var rb []byte
-	enc := codec.NewEncoderBytes(&rb, &mh)
+	enc := codec.NewEncoderBytes(&rb, s.mh)
 	if err := enc.Encode(m); err != nil {
 		Error(fmt.Sprintf("response encode error: %v", err))
 	}
@@ -19,21 +25,28 @@ func response(m map[string]interface{}) []byte {
 	return rb
 }
 
-func errorResponse(m map[string]interface{}) []byte {
+func (s *Store) response(m map[string]interface{}) map[string]interface{} {
+	if _, ok := m["status"]; !ok {
+		m["status"] = true
+	}
+	return m
+}
+
+func (s *Store) errorResponse(m map[string]interface{}) map[string]interface{} {
 	m["status"] = false
-	return response(m)
+	return s.response(m)
 }
 
-func responseOK() []byte {
-	return response(
+func (s *Store) responseOK() map[string]interface{} {
+	return s.response(
 		map[string]interface{}{
 			"msg": "OK",
 		},
 	)
 }
 
-func responseCmdDecodeError(e string) []byte {
-	return errorResponse(
+func (s *Store) responseCmdDecodeError(e string) map[string]interface{} {
+	return s.errorResponse(
 		map[string]interface{}{
 			"code": ErrorCodeCommandDecodeError,
 			"msg":  e,
@@ -41,8 +54,8 @@ func responseCmdDecodeError(e string) []byte {
 	)
 }
 
-func responseCmdFormatError(e string) []byte {
-	return errorResponse(
+func (s *Store) responseCmdFormatError(e string) map[string]interface{} {
+	return s.errorResponse(
 		map[string]interface{}{
 			"code": ErrorCodeCommandFormatError,
 			"msg":  e,
@@ -50,8 +63,8 @@ func responseCmdFormatError(e string) []byte {
 	)
 }
 
-func responseCmdNotFoundError() []byte {
-	return errorResponse(
+func (s *Store) responseCmdNotFoundError() map[string]interface{} {
+	return s.errorResponse(
 		map[string]interface{}{
 			"code": ErrorCodeCommandNotFoundError,
 			"msg":  fmt.Sprintf("cmd format error: not found cmd"),
@@ -59,8 +72,17 @@ func responseCmdNotFoundError() []byte {
 	)
 }
 
-func responseCmdExecuteError(e string) []byte {
-	return errorResponse(
+func (s *Store) responseMaxClientsError() map[string]interface{} {
+	return s.errorResponse(
+		map[string]interface{}{
+			"code": ErrorCodeMaxClientsError,
+			"msg":  MaxClientsError.Error(),
+		},
+	)
+}
+
+func (s *Store) responseCmdExecuteError(e string) map[string]interface{} {
+	return s.errorResponse(
 		map[string]interface{}{
 			"code": ErrorCodeCommandExecuteError,
 			"msg":  e,
@@ -0,0 +1,381 @@
+package memds
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const defaultScriptTimeout = 5 * time.Second
+
+// scriptLibs are the lua libraries scripts can use.
+var scriptLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// scripts caches compiled scripts by the sha1 of their source and tracks
+// the running one. Scripts run one at a time, see Store.smu.
+type scripts struct {
+	mu     sync.Mutex
+	protos map[string]*lua.FunctionProto
+	// cancel stops the running script, nil if none runs.
+	cancel context.CancelFunc
+	killed bool
+}
+
+// load compiles src and caches it under its sha1, which it returns.
+func (sc *scripts) load(src string) (string, *lua.FunctionProto, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+
+	sc.mu.Lock()
+	p, ok := sc.protos[sha]
+	sc.mu.Unlock()
+	if ok {
+		return sha, p, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(src), "script")
+	if err != nil {
+		return "", nil, err
+	}
+	p, err = lua.Compile(chunk, "script")
+	if err != nil {
+		return "", nil, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.protos == nil {
+		sc.protos = make(map[string]*lua.FunctionProto)
+	}
+	sc.protos[sha] = p
+	return sha, p, nil
+}
+
+func (sc *scripts) get(sha string) (*lua.FunctionProto, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	p, ok := sc.protos[strings.ToLower(sha)]
+	return p, ok
+}
+
+func (sc *scripts) exists(sha string) bool {
+	_, ok := sc.get(sha)
+	return ok
+}
+
+func (sc *scripts) flush() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.protos = nil
+}
+
+func (sc *scripts) start(cancel context.CancelFunc) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.cancel = cancel
+	sc.killed = false
+}
+
+// finish clears the running script and reports whether it was killed.
+func (sc *scripts) finish() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.cancel = nil
+	return sc.killed
+}
+
+func (sc *scripts) kill() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.cancel == nil {
+		return NoScriptRunningError
+	}
+	sc.killed = true
+	sc.cancel()
+	return nil
+}
+
+func (s *Store) execScript(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	switch name {
+	case "eval":
+		src, errRes := s.stringArg(cmd, "script")
+		if errRes != nil {
+			return errRes
+		}
+		_, p, err := s.scripts.load(src)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.runScript(p, cmd, c)
+	case "evalsha":
+		sha, errRes := s.stringArg(cmd, "sha")
+		if errRes != nil {
+			return errRes
+		}
+		p, ok := s.scripts.get(sha)
+		if !ok {
+			return s.responseCmdExecuteError(NoScriptError.Error())
+		}
+		return s.runScript(p, cmd, c)
+	}
+
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	switch sub {
+	case "load":
+		src, errRes := s.stringArg(cmd, "script")
+		if errRes != nil {
+			return errRes
+		}
+		sha, _, err := s.scripts.load(src)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.response(map[string]interface{}{"value": sha})
+	case "exists":
+		shas, errRes := s.stringsArg(cmd, "shas")
+		if errRes != nil {
+			return errRes
+		}
+		r := make([]interface{}, 0, len(shas))
+		for _, sha := range shas {
+			r = append(r, boolInt(s.scripts.exists(sha)))
+		}
+		return s.response(map[string]interface{}{"value": r})
+	case "flush":
+		s.scripts.flush()
+		return s.responseOK()
+	case "kill":
+		if err := s.scripts.kill(); err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.responseOK()
+	default:
+		return s.responseCmdExecuteError(fmt.Sprintf("script subcommand '%s' not found", sub))
+	}
+}
+
+// runScript runs p with the "keys" and "args" of cmd as KEYS and ARGV. No
+// other command runs until it returns, at the latest after
+// Config.ScriptTimeout or once it is killed. Writes done before that stay.
+func (s *Store) runScript(p *lua.FunctionProto, cmd map[string]interface{}, c *client) map[string]interface{} {
+	var keys, args []string
+	var errRes map[string]interface{}
+	if _, ok := cmd["keys"]; ok {
+		if keys, errRes = s.stringsArg(cmd, "keys"); errRes != nil {
+			return errRes
+		}
+	}
+	if _, ok := cmd["args"]; ok {
+		if args, errRes = s.stringsArg(cmd, "args"); errRes != nil {
+			return errRes
+		}
+	}
+
+	L := s.newScriptState(keys, c)
+	defer L.Close()
+
+	s.smu.Lock()
+	defer s.smu.Unlock()
+
+	timeout := s.Config().ScriptTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	s.scripts.start(cancel)
+
+	L.SetGlobal("KEYS", scriptStrings(L, keys))
+	L.SetGlobal("ARGV", scriptStrings(L, args))
+	L.Push(L.NewFunctionFromProto(p))
+	err := L.PCall(0, 1, nil)
+	killed := s.scripts.finish()
+	// A script that returned keeps its result even if the deadline passed
+	// meanwhile.
+	switch {
+	case err == nil:
+	case killed:
+		return s.responseCmdExecuteError(ScriptKilledError.Error())
+	case ctx.Err() != nil:
+		return s.responseCmdExecuteError(ScriptTimeoutError.Error())
+	default:
+		return s.responseCmdExecuteError(err.Error())
+	}
+	v := fromLua(L.Get(-1))
+	L.Pop(1)
+	return s.response(map[string]interface{}{"value": v})
+}
+
+// newScriptState returns a lua state with the safe libraries and the memds
+// module, whose call and pcall run commands on keys as c.
+func (s *Store) newScriptState(keys []string, c *client) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range scriptLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Scripts don't touch files.
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	declared := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		declared[k] = true
+	}
+	call := func(L *lua.LState, protect bool) int {
+		cmd, ok := fromLua(L.CheckTable(1)).(map[string]interface{})
+		if !ok {
+			L.ArgError(1, "command not a table with fields")
+		}
+		res := s.callFromScript(cmd, declared, c)
+		if ok, _ := res["status"].(bool); !ok {
+			msg := fmt.Sprintf("%v", res["msg"])
+			if !protect {
+				L.RaiseError("%s", msg)
+			}
+			t := L.NewTable()
+			t.RawSetString("err", lua.LString(msg))
+			L.Push(t)
+			return 1
+		}
+		v := res["value"]
+		if r, ok := v.(rawValue); ok {
+			d, err := r.decode(s.mh)
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			v = d
+		}
+		L.Push(toLua(L, v))
+		return 1
+	}
+
+	mod := L.NewTable()
+	L.SetField(mod, "call", L.NewFunction(func(L *lua.LState) int { return call(L, false) }))
+	L.SetField(mod, "pcall", L.NewFunction(func(L *lua.LState) int { return call(L, true) }))
+	L.SetGlobal("memds", mod)
+	return L
+}
+
+// callFromScript runs cmd for a script that declared the keys in declared.
+func (s *Store) callFromScript(cmd map[string]interface{}, declared map[string]bool, c *client) map[string]interface{} {
+	spec := s.lookupCommand(commandName(cmd))
+	if spec == nil {
+		return s.dispatch(cmd, c)
+	}
+	if spec.flags&(cmdNoScript|cmdBlocking) != 0 {
+		return s.responseCmdExecuteError(ScriptCommandError.Error())
+	}
+	for _, k := range spec.commandKeys(cmd) {
+		if !declared[k] {
+			return s.responseCmdExecuteError(ScriptKeyError.Error())
+		}
+	}
+	return s.dispatch(cmd, c)
+}
+
+func scriptStrings(L *lua.LState, ss []string) *lua.LTable {
+	t := L.CreateTable(len(ss), 0)
+	for _, s := range ss {
+		t.Append(lua.LString(s))
+	}
+	return t
+}
+
+// toLua converts a command result to a lua value. Lists become sequences and
+// maps tables with fields.
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch t := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(t)
+	case string:
+		return lua.LString(t)
+	case []byte:
+		return lua.LString(t)
+	case int:
+		return lua.LNumber(t)
+	case int64:
+		return lua.LNumber(t)
+	case uint64:
+		return lua.LNumber(t)
+	case float64:
+		return lua.LNumber(t)
+	case float32:
+		return lua.LNumber(t)
+	case []interface{}:
+		tbl := L.CreateTable(len(t), 0)
+		for _, e := range t {
+			tbl.Append(toLua(L, e))
+		}
+		return tbl
+	case map[string]interface{}:
+		tbl := L.CreateTable(0, len(t))
+		for k, e := range t {
+			tbl.RawSetString(k, toLua(L, e))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprintf("%v", t))
+	}
+}
+
+// fromLua converts a lua value to a command argument or result. Whole
+// numbers become int64, sequences lists and other tables maps.
+func fromLua(v lua.LValue) interface{} {
+	switch t := v.(type) {
+	case lua.LBool:
+		return bool(t)
+	case lua.LString:
+		return string(t)
+	case lua.LNumber:
+		f := float64(t)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f)
+		}
+		return f
+	case *lua.LTable:
+		if n := t.Len(); n > 0 {
+			r := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				r = append(r, fromLua(t.RawGetInt(i)))
+			}
+			return r
+		}
+		r := make(map[string]interface{})
+		t.ForEach(func(k, e lua.LValue) {
+			r[k.String()] = fromLua(e)
+		})
+		return r
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+package memds
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScript(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	testCase := []struct {
+		Script string
+		Keys   []interface{}
+		Args   []interface{}
+		Value  interface{}
+		Msg    string
+	}{
+		{
+			Script: `return {1, 2.5, "a", true}`,
+			Value:  []interface{}{int64(1), 2.5, "a", true},
+		},
+		{
+			Script: `return {n = tonumber(ARGV[1]) * 2}`,
+			Args:   []interface{}{"21"},
+			Value:  map[string]interface{}{"n": int64(42)},
+		},
+		{
+			Script: `memds.call({cmd = "set", key = KEYS[1], value = ARGV[1]})
+				return memds.call({cmd = "get", key = KEYS[1]})`,
+			Keys:  []interface{}{"k"},
+			Args:  []interface{}{"v"},
+			Value: "v",
+		},
+		{
+			Script: `memds.call({cmd = "rpush", key = KEYS[1], values = {"a", "b"}})
+				return memds.call({cmd = "lrange", key = KEYS[1]})`,
+			Keys:  []interface{}{"l"},
+			Value: []interface{}{"a", "b"},
+		},
+		{
+			Script: `return memds.call({cmd = "get", key = "other"})`,
+			Keys:   []interface{}{"k"},
+			Msg:    ScriptKeyError.Error(),
+		},
+		{
+			Script: `return memds.pcall({cmd = "lpush", key = KEYS[1], value = "x"}).err`,
+			Keys:   []interface{}{"k"},
+			Value:  WrongTypeError.Error(),
+		},
+		{
+			Script: `return memds.call({cmd = "blpop", keys = KEYS[1]})`,
+			Keys:   []interface{}{"l"},
+			Msg:    ScriptCommandError.Error(),
+		},
+		{
+			Script: `return memds.call({cmd = "xread", keys = KEYS[1], ids = "0"})`,
+			Keys:   []interface{}{"x"},
+			Msg:    ScriptCommandError.Error(),
+		},
+		{
+			Script: `return dofile`,
+			Value:  nil,
+		},
+	}
+	for _, tc := range testCase {
+		cmd := map[string]interface{}{"cmd": "eval", "script": tc.Script, "keys": tc.Keys, "args": tc.Args}
+		if tc.Keys == nil {
+			delete(cmd, "keys")
+		}
+		if tc.Args == nil {
+			delete(cmd, "args")
+		}
+		res := exec(cmd)
+		if tc.Msg != "" {
+			if msg, _ := res["msg"].(string); !strings.Contains(msg, tc.Msg) {
+				t.Errorf("%s got: %v, want: %v", tc.Script, res, tc.Msg)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%s got: %v, want: %v", tc.Script, res, tc.Value)
+		}
+	}
+
+	res := exec(map[string]interface{}{"cmd": "script", "sub": "load", "script": `return ARGV[1]`})
+	sha, _ := res["value"].(string)
+	if len(sha) != 40 {
+		t.Fatalf("got: %v, want: a sha1", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "evalsha", "sha": sha, "args": "x"})
+	if res["value"] != "x" {
+		t.Errorf("got: %v, want: x", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "script", "sub": "exists", "shas": []interface{}{sha, "missing"}})
+	if !reflect.DeepEqual(res["value"], []interface{}{1, 0}) {
+		t.Errorf("got: %v, want: [1 0]", res)
+	}
+	exec(map[string]interface{}{"cmd": "script", "sub": "flush"})
+	res = exec(map[string]interface{}{"cmd": "evalsha", "sha": sha})
+	if res["msg"] != NoScriptError.Error() {
+		t.Errorf("got: %v, want: %v", res, NoScriptError)
+	}
+	res = exec(map[string]interface{}{"cmd": "eval", "script": `return (`})
+	if res["status"] != false {
+		t.Errorf("got: %v, want: a syntax error", res)
+	}
+}
+
+func TestScriptTimeoutAndKill(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	loop := map[string]interface{}{"cmd": "eval", "script": `while true do end`}
+
+	s.Config().ScriptTimeout.Duration = 20 * time.Millisecond
+	res := exec(loop)
+	if res["msg"] != ScriptTimeoutError.Error() {
+		t.Errorf("got: %v, want: %v", res, ScriptTimeoutError)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "script", "sub": "kill"})
+	if res["msg"] != NoScriptRunningError.Error() {
+		t.Errorf("got: %v, want: %v", res, NoScriptRunningError)
+	}
+
+	s.Config().ScriptTimeout.Duration = time.Minute
+	done := make(chan map[string]interface{})
+	go func() {
+		done <- exec(loop)
+	}()
+	waitScript(s)
+	res = exec(map[string]interface{}{"cmd": "script", "sub": "kill"})
+	if res["status"] != true {
+		t.Errorf("got: %v, want: true", res)
+	}
+	select {
+	case res := <-done:
+		if res["msg"] != ScriptKilledError.Error() {
+			t.Errorf("got: %v, want: %v", res, ScriptKilledError)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("script not killed")
+	}
+}
+
+func TestScriptAtomic(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	done := make(chan map[string]interface{})
+	go func() {
+		done <- exec(map[string]interface{}{
+			"cmd": "eval",
+			"script": `memds.call({cmd = "set", key = KEYS[1], value = "script"})
+				for i = 1, 1000000 do end
+				return memds.call({cmd = "get", key = KEYS[1]})`,
+			"keys": "k",
+		})
+	}()
+	waitScript(s)
+	exec(map[string]interface{}{"cmd": "set", "key": "k", "value": "client"})
+
+	if res := <-done; res["value"] != "script" {
+		t.Errorf("got: %v, want: script", res)
+	}
+	if v, _ := s.Get("k"); !reflect.DeepEqual(v, []byte("client")) {
+		t.Errorf("got: %v, want: client", v)
+	}
+
+	// A blocked pop lets scripts run and gets what they push.
+	go func() {
+		done <- exec(map[string]interface{}{"cmd": "blpop", "keys": "l", "timeout": 1})
+	}()
+	for !waiting(s, "l", 1) {
+		time.Sleep(time.Millisecond)
+	}
+	exec(map[string]interface{}{"cmd": "eval", "script": `return memds.call({cmd = "rpush", key = KEYS[1], value = "x"})`, "keys": "l"})
+	res := <-done
+	if v, _ := res["value"].(map[string]interface{}); v["value"] != "x" {
+		t.Errorf("got: %v, want: x", res)
+	}
+}
+
+// waitScript waits until a script runs on s.
+func waitScript(s *Store) {
+	for {
+		s.scripts.mu.Lock()
+		running := s.scripts.cancel != nil
+		s.scripts.mu.Unlock()
+		if running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScriptRawValues(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2, RawValues: true})
+	s.exec(encodeTest(t, map[string]interface{}{"cmd": "set", "key": "k", "value": "hello"}), new(client))
+
+	res := s.execute(map[string]interface{}{"cmd": "eval", "script": `return memds.call({cmd = "get", key = KEYS[1]}) .. "!"`, "keys": "k"}, new(client))
+	if res["value"] != "hello!" {
+		t.Errorf("got: %v, want: hello!", res)
+	}
+}
@@ -3,30 +3,228 @@ package memds
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
-func Serve(c *Config) error {
-	var (
-		l   net.Listener
-		err error
-	)
+const (
+	expireInterval         = time.Second
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// errDraining is returned by readCommand once the server stopped taking
+// new commands.
+var errDraining = errors.New("server is draining")
+
+// Server serves a Store over a tcp or unix socket listener.
+type Server struct {
+	store    *Store
+	config   *Config
+	listener net.Listener
+	// httpListener is nil unless Config.HTTPPort is set.
+	httpListener net.Listener
+	// memcachedListener is nil unless Config.MemcachedPort is set.
+	memcachedListener net.Listener
+	// metricsListener is nil unless Config.MetricsPort is set.
+	metricsListener net.Listener
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
+	done            chan struct{}
+	// draining is set with sync/atomic once Shutdown or Close is called.
+	draining int32
+
+	mu sync.Mutex
+	// running is set once Start succeeds.
+	running bool
+	// idleConns are the connections waiting between commands, which
+	// Shutdown wakes up.
+	idleConns map[net.Conn]struct{}
+}
+
+func NewServer(s *Store) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := Server{
+		store:     s,
+		config:    s.Config(),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		idleConns: make(map[net.Conn]struct{}),
+	}
+	return &srv
+}
+
+func (s *Server) Store() *Store {
+	return s.store
+}
 
-	buckets, err = NewBuckets(c.BucketNum)
+// Addr returns the listener address. It is nil until Start succeeds.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Start opens the listener and serves connections in the background until
+// Shutdown is called.
+func (s *Server) Start() error {
+	l, err := listener(s.config)
 	if err != nil {
 		return err
 	}
+	s.listener = l
+
+	if err := s.startHTTP(); err != nil {
+		l.Close()
+		return err
+	}
+	if err := s.startMemcached(); err != nil {
+		s.closeListeners()
+		return err
+	}
+	if err := s.startMetrics(); err != nil {
+		s.closeListeners()
+		return err
+	}
+
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+
+	go s.serve()
+	go s.expire()
+	return nil
+}
+
+// Shutdown gracefully stops the server. It closes the listeners and idle
+// connections, lets in-flight commands finish and flush their responses,
+// then waits for every connection to close. If ctx is done first the
+// remaining connections are closed immediately and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.closeListeners()
+	s.store.StopBlocking()
+
+	// Wake up connections waiting for a command, busy ones check draining
+	// once their response is written.
+	now := time.Now()
+	s.mu.Lock()
+	running := s.running
+	for c := range s.idleConns {
+		c.SetReadDeadline(now)
+	}
+	s.mu.Unlock()
+
+	if !running {
+		s.cancel()
+		return nil
+	}
+	select {
+	case <-s.done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes the listeners and every open connection, then
+// waits for the connection handlers to return.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.store.StopBlocking()
+	s.cancel()
+	s.closeListeners()
+
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		<-s.done
+	}
+	return nil
+}
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *Server) closeListeners() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.httpListener != nil {
+		s.httpListener.Close()
+	}
+	if s.memcachedListener != nil {
+		s.memcachedListener.Close()
+	}
+	if s.metricsListener != nil {
+		s.metricsListener.Close()
+	}
+}
+
+// expire periodically removes expired keys until the server is shut down.
+func (s *Server) expire() {
+	t := time.NewTicker(expireInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+			s.store.DeleteExpired()
+		}
+	}
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil && s.isDraining() {
+			break
+		}
+		if err != nil {
+			Error(err.Error())
+			continue
+		}
+		s.wg.Add(1)
+		go s.accept(conn)
+	}
+
+	s.wg.Wait()
+}
+
+func Serve(c *Config) error {
+	if err := SetLogLevel(c.LogLevel); err != nil {
+		return err
+	}
 
-	l, err = listener(c)
+	st, err := NewStore(c)
 	if err != nil {
 		return err
 	}
+	defaultStore = st
+
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		return err
+	}
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(
@@ -37,37 +235,43 @@ func Serve(c *Config) error {
 		syscall.SIGQUIT,
 	)
 
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-
-	var wg sync.WaitGroup
-	closed := false
-	go func() {
-		<-sig
-		cancel()
-		closed = true
-		l.Close()
-	}()
-
 	for {
-		conn, err := l.Accept()
-		if err != nil && closed {
-			break
-		}
-		if err != nil {
-			Error(err.Error())
+		switch <-sig {
+		case syscall.SIGHUP:
+			Info("reload config")
+			if err := st.ReloadConfig(); err != nil {
+				Error(fmt.Sprintf("reload config error: %v", err))
+			}
 			continue
+		case syscall.SIGQUIT:
+			Info("shutdown memds immediately")
+			return srv.Close()
 		}
-		wg.Add(1)
-		go accept(ctx, &wg, conn)
+		break
 	}
 
-	if !closed {
-		l.Close()
+	timeout := st.Config().ShutdownTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	wg.Wait()
-	return nil
+	// A second signal skips the rest of the drain.
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	Info("shutdown memds")
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		Warn(fmt.Sprintf("shutdown drain not finished: %v", err))
+	}
+	return err
 }
 
 func listener(c *Config) (net.Listener, error) {
@@ -77,30 +281,51 @@ func listener(c *Config) (net.Listener, error) {
 	return net.Listen("unix", c.Sock)
 }
 
-func accept(ctx context.Context, wg *sync.WaitGroup, c net.Conn) {
-	closed := false
+func (s *Server) accept(c net.Conn) {
+	closed := make(chan struct{})
+	s.store.metrics.clientConnected()
 
 	go func() {
-		<-ctx.Done()
-		closed = true
-		c.Close()
+		select {
+		case <-s.ctx.Done():
+			c.Close()
+		case <-closed:
+		}
 	}()
 
 	defer func() {
-		if !closed {
-			c.Close()
-		}
-		wg.Done()
+		close(closed)
+		c.Close()
+		s.forget(c)
+		s.store.metrics.clientDisconnected()
+		s.wg.Done()
 	}()
 
+	cl := newClient(c)
+	if !s.store.clients.add(cl, s.store.Config().MaxClients) {
+		s.setWriteDeadline(c)
+		c.Write(s.store.encodeResponse(s.store.responseMaxClientsError()))
+		Warn(fmt.Sprintf("reject %s: %v", cl.addr, MaxClientsError))
+		return
+	}
+	defer s.store.clients.remove(cl)
+
 	r := bufio.NewReader(c)
+	cl.reader = r
 
 	for {
-		line, _, err := r.ReadLine()
+		if s.isDraining() {
+			break
+		}
+		line, err := s.readCommand(c, r)
 		if err == io.EOF {
 			break
 		}
-		if err != nil && closed {
+		if err != nil && s.isDraining() {
+			break
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			Info(fmt.Sprintf("close %s: %v", cl.addr, err))
 			break
 		}
 		if err != nil {
@@ -108,12 +333,138 @@ func accept(ctx context.Context, wg *sync.WaitGroup, c net.Conn) {
 			break
 		}
 
-		res := Exec(line)
+		cl.read(len(line) + 1)
+		res := s.store.exec(line, cl)
 
-		_, err = c.Write(res)
+		s.setWriteDeadline(c)
+		n, err := c.Write(res)
+		cl.wrote(n)
 		if err != nil {
 			Error(fmt.Sprintf("%v", err))
 			break
 		}
+
+		if cl.monitor != nil {
+			s.streamMonitor(cl, r)
+			break
+		}
+	}
+}
+
+// readCommand reads one command line from r.
+func (s *Server) readCommand(c net.Conn, r *bufio.Reader) ([]byte, error) {
+	if err := s.waitCommand(c, r); err != nil {
+		return nil, err
+	}
+	line, _, err := r.ReadLine()
+	return line, err
+}
+
+// waitCommand waits up to Config.IdleTimeout for a command to start
+// arriving on c and gives the rest of it Config.ReadTimeout. Once the server
+// drains it returns errDraining instead of waiting, a command that started
+// arriving is still read.
+func (s *Server) waitCommand(c net.Conn, r *bufio.Reader) error {
+	if r.Buffered() == 0 {
+		s.idle(c, s.store.Config().IdleTimeout.Duration)
+		// Shutdown may have woken the connection up before it was idle.
+		if s.isDraining() {
+			return errDraining
+		}
+		if _, err := r.Peek(1); err != nil {
+			return err
+		}
+	}
+	s.busy(c, s.store.Config().ReadTimeout.Duration)
+	return nil
+}
+
+// idle marks c as waiting between commands with a read deadline of d.
+// Shutdown wakes idle connections up by expiring their read deadline.
+func (s *Server) idle(c net.Conn, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idleConns[c] = struct{}{}
+	setReadDeadline(c, d)
+}
+
+// busy marks c as reading a command with a read deadline of d. Shutdown
+// lets busy connections finish their command.
+func (s *Server) busy(c net.Conn, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idleConns, c)
+	setReadDeadline(c, d)
+}
+
+// forget drops a closed connection.
+func (s *Server) forget(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idleConns, c)
+}
+
+func setReadDeadline(c net.Conn, d time.Duration) {
+	if d > 0 {
+		c.SetReadDeadline(time.Now().Add(d))
+	} else {
+		c.SetReadDeadline(time.Time{})
+	}
+}
+
+func (s *Server) setWriteDeadline(c net.Conn) {
+	if d := s.store.Config().WriteTimeout.Duration; d > 0 {
+		c.SetWriteDeadline(time.Now().Add(d))
+	}
+}
+
+// deadlineWriter gives every write to c Config.WriteTimeout, for responses
+// written through a bufio.Writer.
+type deadlineWriter struct {
+	s *Server
+	c net.Conn
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	w.s.setWriteDeadline(w.c)
+	return w.c.Write(p)
+}
+
+// streamMonitor writes monitor events to c until the client disconnects or
+// the server is shut down.
+func (s *Server) streamMonitor(c *client, r *bufio.Reader) {
+	m := c.monitor
+	defer s.store.monitors.unsubscribe(m)
+
+	// The stream has no idle time, only a disconnect or Shutdown ends it.
+	s.idle(c.conn, 0)
+	if s.isDraining() {
+		return
+	}
+
+	// Anything the client sends ends the stream.
+	done := make(chan struct{})
+	go func() {
+		r.ReadByte()
+		close(done)
+	}()
+
+	for {
+		select {
+		case ev := <-m.events:
+			s.setWriteDeadline(c.conn)
+			n, err := c.conn.Write(ev)
+			c.wrote(n)
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-s.ctx.Done():
+			return
+		}
 	}
 }
@@ -0,0 +1,240 @@
+package memds
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestServerStartAndShutdown(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer conn.Close()
+
+	var b []byte
+	enc := codec.NewEncoderBytes(&b, &mh)
+	if err := enc.Encode(map[string]interface{}{"cmd": "set", "key": "key", "value": "value"}); err != nil {
+		t.Fatal("command encode error")
+	}
+	b = append(b, '\n')
+	if _, err := conn.Write(b); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if _, _, err := bufio.NewReader(conn).ReadLine(); err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	v, err := st.Get("key")
+	if err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+	if !reflect.DeepEqual(v, []byte("value")) {
+		t.Errorf("got: %v, want: %v", v, []byte("value"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+}
+
+func TestServerMaxClients(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2, MaxClients: 1})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	c0, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c0.Close()
+
+	// Wait for the first connection to be registered.
+	for i := 0; i < 100 && len(st.clients.list()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c1, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c1.Close()
+
+	r, _, err := bufio.NewReader(c1).ReadLine()
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	res := make(map[string]interface{})
+	dec := codec.NewDecoderBytes(r, &mh)
+	if err := dec.Decode(&res); err != nil {
+		t.Fatalf("response decode error: %v", err)
+	}
+	if res["status"] != false {
+		t.Errorf("got: %v, want: false", res["status"])
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2, IdleTimeout: Duration{50 * time.Millisecond}})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	c, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got: %v, want: %v", err, io.EOF)
+	}
+}
+
+func TestServerMemcachedIdleTimeout(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2, IdleTimeout: Duration{50 * time.Millisecond}})
+	srv := NewServer(st)
+	c, sc := net.Pipe()
+	defer c.Close()
+	srv.wg.Add(1)
+	go srv.acceptMemcached(sc)
+
+	c.SetDeadline(time.Now().Add(time.Second))
+	if _, err := c.Write([]byte("set key 0 0 1\r\nx\r\n")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	line, _, err := bufio.NewReader(c).ReadLine()
+	if err != nil || string(line) != "STORED" {
+		t.Fatalf("got: %q, %v, want: STORED", line, err)
+	}
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got: %v, want: %v", err, io.EOF)
+	}
+}
+
+func TestServerShutdownDrain(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+
+	c, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 100 && len(st.clients.list()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got: %v, want: %v", err, io.EOF)
+	}
+
+	if _, err := net.Dial("tcp", srv.Addr().String()); err == nil {
+		t.Error("listener should be closed")
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+
+	c, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	defer c.Close()
+
+	if err := srv.Close(); err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+	if srv.ctx.Err() == nil {
+		t.Error("connections should be cancelled")
+	}
+}
+
+func TestServerShutdownNotStarted(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	srv := NewServer(st)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+}
+
+func TestServerShutdownMemcachedBusy(t *testing.T) {
+	st, _ := NewStore(&Config{BucketNum: 2})
+	srv := NewServer(st)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("start error: %v", err)
+	}
+	c, sc := net.Pipe()
+	defer c.Close()
+	srv.wg.Add(1)
+	go srv.acceptMemcached(sc)
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	// A command half read when the drain starts is finished.
+	if _, err := c.Write([]byte("set key 0 0 5\r\nva")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := c.Write([]byte("lue\r\n")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	line, _, err := bufio.NewReader(c).ReadLine()
+	if err != nil || string(line) != "STORED" {
+		t.Errorf("got: %q, %v, want: STORED", line, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+	if v, _ := st.Get("key"); !reflect.DeepEqual(v, []byte("value")) {
+		t.Errorf("got: %v, want: value", v)
+	}
+}
@@ -0,0 +1,159 @@
+package memds
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlowlogMaxLen = 128
+	slowlogMaxKeyLen     = 64
+)
+
+type slowlogEntry struct {
+	id       uint64
+	time     time.Time
+	duration time.Duration
+	cmd      string
+	key      string
+	addr     string
+}
+
+// slowlog keeps the latest commands slower than the configured threshold in
+// a ring buffer.
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	// head is the index the next entry is written to.
+	head int
+	n    int
+	seq  uint64
+}
+
+func (l *slowlog) add(e slowlogEntry, maxLen int) {
+	if maxLen <= 0 {
+		maxLen = defaultSlowlogMaxLen
+	}
+	if len(e.key) > slowlogMaxKeyLen {
+		e.key = e.key[:slowlogMaxKeyLen]
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) != maxLen {
+		l.resize(maxLen)
+	}
+
+	l.seq++
+	e.id = l.seq
+	l.entries[l.head] = e
+	l.head = (l.head + 1) % len(l.entries)
+	if l.n < len(l.entries) {
+		l.n++
+	}
+}
+
+// resize keeps the newest entries that fit in maxLen. The caller must hold
+// l.mu.
+func (l *slowlog) resize(maxLen int) {
+	kept := l.latest(maxLen)
+	l.entries = make([]slowlogEntry, maxLen)
+	l.n = len(kept)
+	for i := range kept {
+		l.entries[i] = kept[len(kept)-1-i]
+	}
+	l.head = l.n % maxLen
+}
+
+// latest returns up to count entries, newest first. The caller must hold
+// l.mu.
+func (l *slowlog) latest(count int) []slowlogEntry {
+	if count < 0 || count > l.n {
+		count = l.n
+	}
+	r := make([]slowlogEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		j := (l.head - i + len(l.entries)) % len(l.entries)
+		r = append(r, l.entries[j])
+	}
+	return r
+}
+
+func (l *slowlog) get(count int) []slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.latest(count)
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.n
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+	l.head = 0
+	l.n = 0
+}
+
+// recordSlow adds the command to the slowlog if it took longer than
+// Config.SlowlogThreshold.
+func (s *Store) recordSlow(cmd map[string]interface{}, name string, start time.Time, d time.Duration, addr string) {
+	c := s.Config()
+	threshold := c.SlowlogThreshold.Duration
+	if threshold <= 0 || d < threshold {
+		return
+	}
+	key, _ := s.optionalStringArg(cmd, "key")
+	s.slowlog.add(
+		slowlogEntry{
+			time:     start,
+			duration: d,
+			cmd:      name,
+			key:      key,
+			addr:     addr,
+		},
+		c.SlowlogMaxLen,
+	)
+}
+
+func (s *Store) execSlowlog(cmd map[string]interface{}) map[string]interface{} {
+	sub, errRes := s.stringArg(cmd, "sub")
+	if errRes != nil {
+		return errRes
+	}
+	switch sub {
+	case "get":
+		count, errRes := s.optionalIntArg(cmd, "count", -1)
+		if errRes != nil {
+			return errRes
+		}
+		entries := s.slowlog.get(int(count))
+		r := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			r = append(r, map[string]interface{}{
+				"id":       e.id,
+				"time":     e.time.Unix(),
+				"duration": int64(e.duration / time.Microsecond),
+				"cmd":      e.cmd,
+				"key":      e.key,
+				"addr":     e.addr,
+			})
+		}
+		return s.response(map[string]interface{}{"value": r})
+	case "len":
+		return s.response(map[string]interface{}{"value": s.slowlog.len()})
+	case "reset":
+		s.slowlog.reset()
+		return s.responseOK()
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
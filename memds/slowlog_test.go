@@ -0,0 +1,69 @@
+package memds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowlog(t *testing.T) {
+	var l slowlog
+
+	for i := 0; i < 5; i++ {
+		l.add(slowlogEntry{cmd: "get"}, 3)
+	}
+	if l.len() != 3 {
+		t.Errorf("got: %v, want: 3", l.len())
+	}
+
+	testCase := []struct {
+		Count int
+		IDs   []uint64
+	}{
+		{Count: -1, IDs: []uint64{5, 4, 3}},
+		{Count: 2, IDs: []uint64{5, 4}},
+		{Count: 10, IDs: []uint64{5, 4, 3}},
+	}
+	for _, tc := range testCase {
+		entries := l.get(tc.Count)
+		if len(entries) != len(tc.IDs) {
+			t.Errorf("got: %v, want: %v", len(entries), len(tc.IDs))
+			continue
+		}
+		for i, e := range entries {
+			if e.id != tc.IDs[i] {
+				t.Errorf("got: %v, want: %v", e.id, tc.IDs[i])
+			}
+		}
+	}
+
+	l.add(slowlogEntry{cmd: "get"}, 2)
+	entries := l.get(-1)
+	if len(entries) != 2 || entries[0].id != 6 || entries[1].id != 5 {
+		t.Errorf("got: %v, want: ids 6, 5", entries)
+	}
+
+	l.reset()
+	if l.len() != 0 {
+		t.Errorf("got: %v, want: 0", l.len())
+	}
+}
+
+func TestRecordSlow(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+
+	s.recordSlow(map[string]interface{}{"cmd": "get", "key": "key"}, "get", time.Now(), time.Second, "addr")
+	if s.slowlog.len() != 0 {
+		t.Errorf("got: %v, want: 0 when threshold is not set", s.slowlog.len())
+	}
+
+	s.config.SlowlogThreshold = Duration{time.Millisecond}
+	s.recordSlow(map[string]interface{}{"cmd": "get", "key": "key"}, "get", time.Now(), time.Microsecond, "addr")
+	s.recordSlow(map[string]interface{}{"cmd": "get", "key": "key"}, "get", time.Now(), time.Second, "addr")
+	entries := s.slowlog.get(-1)
+	if len(entries) != 1 {
+		t.Fatalf("got: %v, want: 1", len(entries))
+	}
+	if entries[0].key != "key" || entries[0].addr != "addr" || entries[0].duration != time.Second {
+		t.Errorf("got: %v", entries[0])
+	}
+}
@@ -0,0 +1,176 @@
+package memds
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Store is an in-memory key value store. Each Store owns its buckets and
+// codec, so several stores can live in one process.
+type Store struct {
+	// config is replaced, never modified, when the config is reloaded.
+	cmu    sync.RWMutex
+	config *Config
+
+	// bmu guards the bucket layout. While a resize runs, old holds the
+	// previous buckets and those below moved have been moved to buckets.
+	// pending holds the keys of old[moved] not moved yet, nil until its
+	// first keys are moved.
+	bmu      sync.RWMutex
+	buckets  Buckets
+	old      Buckets
+	moved    int
+	pending  map[string]struct{}
+	hash     Hash
+	indexes  *indexes
+	mh       *codec.MsgpackHandle
+	metrics  *Metrics
+	slowlog  slowlog
+	monitors monitors
+	clients  clients
+	waiters  waiters
+	started  time.Time
+
+	// smu is read locked by every command but scripts, which lock it to
+	// run alone.
+	smu     sync.RWMutex
+	scripts scripts
+
+	commands commands
+}
+
+func NewStore(c *Config) (*Store, error) {
+	hash, err := newHash(c.Hash)
+	if err != nil {
+		return nil, err
+	}
+	s := Store{
+		config:  c,
+		hash:    hash,
+		indexes: new(indexes),
+		mh:      newMsgpackHandle(),
+		metrics: newMetrics(),
+		started: time.Now(),
+	}
+	s.buckets, err = s.newBuckets(c.BucketNum)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// newBuckets makes n buckets sharing the codec and indexes of s.
+func (s *Store) newBuckets(n int) (Buckets, error) {
+	b, err := newBuckets(n, s.mh)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range b {
+		e.indexes = s.indexes
+	}
+	return b, nil
+}
+
+func (s *Store) Config() *Config {
+	s.cmu.RLock()
+	defer s.cmu.RUnlock()
+
+	return s.config
+}
+
+func (s *Store) Get(k string) (interface{}, error) {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return nil, BucketNotFoundError
+	}
+	return b.Get(k)
+}
+
+// getRaw is like Get but returns the value as the msgpack bytes it is
+// stored as.
+func (s *Store) getRaw(k string) (interface{}, error) {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return nil, BucketNotFoundError
+	}
+	return b.getRaw(k)
+}
+
+// exists reports whether k is stored and not expired.
+func (s *Store) exists(k string) bool {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return false
+	}
+	return b.exists(k)
+}
+
+func (s *Store) Set(k string, v interface{}) error {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return BucketNotFoundError
+	}
+	return b.Set(k, v)
+}
+
+func (s *Store) Del(k string) error {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	b := s.bucket(k)
+	if b == nil {
+		return BucketNotFoundError
+	}
+	b.Del(k)
+	return nil
+}
+
+// DeleteExpired removes expired keys from every bucket and returns how many
+// were removed.
+func (s *Store) DeleteExpired() int {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	now := time.Now()
+	n := 0
+	for _, b := range s.allBuckets() {
+		n += b.DeleteExpired(now)
+	}
+	s.metrics.keysExpired(n)
+	return n
+}
+
+func (s *Store) Flush() {
+	s.bmu.RLock()
+	defer s.bmu.RUnlock()
+
+	for _, b := range s.allBuckets() {
+		b.Flush()
+	}
+}
+
+func Get(k string) (interface{}, error) {
+	return defaultStore.Get(k)
+}
+
+func Set(k string, v interface{}) error {
+	return defaultStore.Set(k, v)
+}
+
+func Del(k string) error {
+	return defaultStore.Del(k)
+}
@@ -0,0 +1,59 @@
+package memds
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewStore(t *testing.T) {
+	testCase := []struct {
+		In  int
+		Len int
+		Err error
+	}{
+		{
+			In:  5,
+			Len: 5,
+			Err: nil,
+		},
+		{
+			In:  0,
+			Len: 0,
+			Err: BucketsLEZeroError,
+		},
+	}
+	for _, tc := range testCase {
+		s, err := NewStore(&Config{BucketNum: tc.In})
+		if err != tc.Err {
+			t.Errorf("got: %v, want: %v", err, tc.Err)
+		}
+		if err != nil {
+			continue
+		}
+		if s.buckets.Len() != tc.Len {
+			t.Errorf("got: %v, want: %v", s.buckets.Len(), tc.Len)
+		}
+	}
+}
+
+func TestStoreIsolation(t *testing.T) {
+	s0, _ := NewStore(&Config{BucketNum: 2})
+	s1, _ := NewStore(&Config{BucketNum: 2})
+
+	if err := s0.Set("key", []byte("value")); err != nil {
+		t.Fatalf("got: %v, want: nil", err)
+	}
+
+	v, err := s0.Get("key")
+	if err != nil {
+		t.Errorf("got: %v, want: nil", err)
+	}
+	if !reflect.DeepEqual(v, []byte("value")) {
+		t.Errorf("got: %v, want: %v", v, []byte("value"))
+	}
+
+	_, err = s1.Get("key")
+	if err != ValueNotFoundError {
+		t.Errorf("got: %v, want: %v", err, ValueNotFoundError)
+	}
+}
@@ -0,0 +1,726 @@
+package memds
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID identifies a stream entry. ms is the unix time in milliseconds
+// the entry was added at and seq orders entries added in the same
+// millisecond.
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(o streamID) bool {
+	if id.ms != o.ms {
+		return id.ms < o.ms
+	}
+	return id.seq < o.seq
+}
+
+// parseStreamID parses "ms-seq", or "ms" in which case seq is defSeq.
+func parseStreamID(s string, defSeq uint64) (streamID, error) {
+	ms, seq := s, ""
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		ms, seq = s[:i], s[i+1:]
+	}
+	var (
+		id  streamID
+		err error
+	)
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return streamID{}, StreamIDError
+	}
+	id.seq = defSeq
+	if seq != "" {
+		if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return streamID{}, StreamIDError
+		}
+	}
+	return id, nil
+}
+
+// parseStreamRange parses a range bound, "-" is the smallest id and "+" the
+// largest.
+func parseStreamRange(s string, end bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	if end {
+		return parseStreamID(s, math.MaxUint64)
+	}
+	return parseStreamID(s, 0)
+}
+
+type streamEntry struct {
+	id     streamID
+	fields map[string]interface{}
+}
+
+// stream is an append only log of entries ordered by id.
+type stream struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+// streamGroup is a consumer group. Entries read by its consumers stay
+// pending until they are acked.
+type streamGroup struct {
+	lastID    streamID
+	pending   map[streamID]*streamPending
+	consumers map[string]time.Time
+}
+
+type streamPending struct {
+	consumer  string
+	delivered time.Time
+	count     int64
+}
+
+func newStream() interface{} {
+	st := stream{groups: make(map[string]*streamGroup)}
+	return &st
+}
+
+// add appends an entry. A nil id is generated from now, moving on to the
+// next millisecond once the sequence of the last one runs out.
+func (st *stream) add(id *streamID, fields map[string]interface{}, now time.Time) (streamID, error) {
+	var next streamID
+	if id == nil {
+		next.ms = uint64(now.UnixNano() / int64(time.Millisecond))
+		if next.ms <= st.lastID.ms {
+			if st.lastID == maxStreamID {
+				return streamID{}, StreamIDTooSmallError
+			}
+			next = st.lastID
+			if next.seq == math.MaxUint64 {
+				next.ms++
+				next.seq = 0
+			} else {
+				next.seq++
+			}
+		}
+	} else {
+		next = *id
+		if !st.lastID.less(next) {
+			return streamID{}, StreamIDTooSmallError
+		}
+	}
+	st.entries = append(st.entries, streamEntry{id: next, fields: fields})
+	st.lastID = next
+	return next, nil
+}
+
+// search returns the position of the first entry with an id not below id.
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+// between returns up to count entries with ids from start to end, both
+// inclusive. count 0 means no limit.
+func (st *stream) between(start, end streamID, count int, rev bool) []streamEntry {
+	i, j := st.search(start), len(st.entries)
+	for j > i && end.less(st.entries[j-1].id) {
+		j--
+	}
+	es := st.entries[i:j]
+	if count <= 0 || count > len(es) {
+		count = len(es)
+	}
+	r := make([]streamEntry, 0, count)
+	for n := 0; n < count; n++ {
+		if rev {
+			r = append(r, es[len(es)-1-n])
+		} else {
+			r = append(r, es[n])
+		}
+	}
+	return r
+}
+
+// after returns up to count entries with ids above id.
+func (st *stream) after(id streamID, count int) []streamEntry {
+	if id == maxStreamID {
+		return nil
+	}
+	next := streamID{ms: id.ms, seq: id.seq + 1}
+	if id.seq == math.MaxUint64 {
+		next = streamID{ms: id.ms + 1}
+	}
+	return st.between(next, maxStreamID, count, false)
+}
+
+func (st *stream) entry(id streamID) (streamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// size counts entries by their id and fields, and pending entries and
+// consumers of the groups.
+func (st *stream) size() int64 {
+	var n int64
+	for _, e := range st.entries {
+		n += 16 + valueSize(e.fields)
+	}
+	for name, g := range st.groups {
+		n += int64(len(name)) + 16
+		for _, p := range g.pending {
+			n += 16 + int64(len(p.consumer)) + 16
+		}
+		for c := range g.consumers {
+			n += int64(len(c)) + 8
+		}
+	}
+	return n
+}
+
+// trim removes the oldest entries so at most maxLen are left and returns
+// how many were removed.
+func (st *stream) trim(maxLen int) int {
+	n := len(st.entries) - maxLen
+	if maxLen < 0 || n <= 0 {
+		return 0
+	}
+	st.entries = append([]streamEntry(nil), st.entries[n:]...)
+	return n
+}
+
+// readGroup returns entries for consumer. With id ">" they are the entries
+// never delivered to the group, which become pending unless noack is set,
+// otherwise the entries pending for consumer with ids above id.
+func (st *stream) readGroup(g *streamGroup, consumer, id string, count int, noack bool, now time.Time) ([]streamEntry, error) {
+	g.consumers[consumer] = now
+	if id == ">" {
+		es := st.after(g.lastID, count)
+		for _, e := range es {
+			g.lastID = e.id
+			if !noack {
+				g.pending[e.id] = &streamPending{consumer: consumer, delivered: now, count: 1}
+			}
+		}
+		return es, nil
+	}
+
+	after, err := parseStreamID(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	var es []streamEntry
+	for _, pid := range g.pendingIDs(consumer) {
+		if !after.less(pid) {
+			continue
+		}
+		if count > 0 && len(es) == count {
+			break
+		}
+		// Entries trimmed away are still pending, without fields.
+		e, _ := st.entry(pid)
+		e.id = pid
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+// pendingIDs returns the pending ids of consumer, or of every consumer if
+// it is empty, in order.
+func (g *streamGroup) pendingIDs(consumer string) []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id, p := range g.pending {
+		if consumer == "" || p.consumer == consumer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].less(ids[j])
+	})
+	return ids
+}
+
+// stream returns the stream at k, creating it if create is set. It returns
+// nil if k doesn't exist. The caller must hold b.mu, for writing if create is
+// set.
+func (b *Bucket) stream(k string, create bool) (*stream, error) {
+	var f func() interface{}
+	if create {
+		f = newStream
+	}
+	o, err := b.typed(k, f)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	st, ok := o.(*stream)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return st, nil
+}
+
+func streamEntriesValue(es []streamEntry) []interface{} {
+	r := make([]interface{}, 0, len(es))
+	for _, e := range es {
+		r = append(r, map[string]interface{}{
+			"id":     e.id.String(),
+			"fields": e.fields,
+		})
+	}
+	return r
+}
+
+func (s *Store) execStream(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	if name == "xread" || name == "xreadgroup" {
+		return s.execXRead(name, cmd, c)
+	}
+
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		st, err := b.stream(k, false)
+		if err != nil {
+			return err
+		}
+		// A stream created for a command that fails is removed again.
+		if st == nil && (name == "xadd" || (name == "xgroup" && cmd["mkstream"] == true)) {
+			if st, err = b.stream(k, true); err != nil {
+				return err
+			}
+			defer func() {
+				if res["status"] != true {
+					b.remove(k)
+				}
+			}()
+		}
+		switch name {
+		case "xadd":
+			res = s.execXAdd(st, cmd)
+		case "xrange", "xrevrange":
+			res = s.execXRange(st, cmd, name == "xrevrange")
+		case "xlen":
+			n := 0
+			if st != nil {
+				n = len(st.entries)
+			}
+			res = s.response(map[string]interface{}{"value": n})
+		case "xtrim":
+			n, errRes := s.optionalIntArg(cmd, "maxlen", -1)
+			if errRes != nil {
+				res = errRes
+				return nil
+			}
+			if n < 0 {
+				res = s.responseCmdFormatError("key 'maxlen' not found")
+				return nil
+			}
+			removed := 0
+			if st != nil {
+				removed = st.trim(int(n))
+			}
+			res = s.response(map[string]interface{}{"value": removed})
+		default:
+			res = s.execStreamGroup(name, st, cmd)
+		}
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if name == "xadd" && res["status"] == true {
+		s.waiters.notify(k)
+	}
+	return res
+}
+
+func (s *Store) execXAdd(st *stream, cmd map[string]interface{}) map[string]interface{} {
+	fields, ok := cmd["fields"].(map[string]interface{})
+	if !ok || len(fields) == 0 {
+		return s.responseCmdFormatError("key 'fields' not type map")
+	}
+	ids, errRes := s.optionalStringArg(cmd, "id")
+	if errRes != nil {
+		return errRes
+	}
+	maxLen, errRes := s.optionalIntArg(cmd, "maxlen", -1)
+	if errRes != nil {
+		return errRes
+	}
+
+	var id *streamID
+	if ids != "" && ids != "*" {
+		v, err := parseStreamID(ids, 0)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		id = &v
+	}
+	added, err := st.add(id, fields, time.Now())
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if maxLen >= 0 {
+		st.trim(int(maxLen))
+	}
+	return s.response(map[string]interface{}{"value": added.String()})
+}
+
+func (s *Store) execXRange(st *stream, cmd map[string]interface{}, rev bool) map[string]interface{} {
+	bounds := make([]streamID, 0, 2)
+	for i, name := range []string{"start", "end"} {
+		v, errRes := s.optionalStringArg(cmd, name)
+		if errRes != nil {
+			return errRes
+		}
+		if v == "" {
+			v = []string{"-", "+"}[i]
+		}
+		id, err := parseStreamRange(v, i == 1)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		bounds = append(bounds, id)
+	}
+	count, errRes := s.optionalIntArg(cmd, "count", 0)
+	if errRes != nil {
+		return errRes
+	}
+	var es []streamEntry
+	if st != nil {
+		es = st.between(bounds[0], bounds[1], int(count), rev)
+	}
+	return s.response(map[string]interface{}{"value": streamEntriesValue(es)})
+}
+
+// execXRead reads entries after ids from keys, for xreadgroup on behalf of a
+// consumer of a group. With "block" it waits for entries up to that many
+// milliseconds, 0 waits without limit.
+func (s *Store) execXRead(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	keys, errRes := s.stringsArg(cmd, "keys")
+	if errRes != nil {
+		return errRes
+	}
+	ids, errRes := s.stringsArg(cmd, "ids")
+	if name == "xreadgroup" && cmd["ids"] == nil {
+		ids, errRes = make([]string, len(keys)), nil
+		for i := range ids {
+			ids[i] = ">"
+		}
+	}
+	if errRes != nil {
+		return errRes
+	}
+	if len(ids) != len(keys) {
+		return s.responseCmdFormatError("keys and ids differ in length")
+	}
+	count, errRes := s.optionalIntArg(cmd, "count", 0)
+	if errRes != nil {
+		return errRes
+	}
+	block, errRes := s.optionalIntArg(cmd, "block", -1)
+	if errRes != nil {
+		return errRes
+	}
+	var group, consumer string
+	if name == "xreadgroup" {
+		if group, errRes = s.stringArg(cmd, "group"); errRes != nil {
+			return errRes
+		}
+		if consumer, errRes = s.stringArg(cmd, "consumer"); errRes != nil {
+			return errRes
+		}
+	}
+	noack := cmd["noack"] == true
+
+	// "$" is the last id when the command arrives, later entries are new.
+	after := make([]streamID, len(keys))
+	for i, k := range keys {
+		if name == "xreadgroup" {
+			continue
+		}
+		if ids[i] == "$" {
+			err := s.view(k, func(b *Bucket) error {
+				st, err := b.stream(k, false)
+				if st != nil {
+					after[i] = st.lastID
+				}
+				return err
+			})
+			if err != nil {
+				return s.responseCmdExecuteError(err.Error())
+			}
+			continue
+		}
+		id, err := parseStreamID(ids[i], 0)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		after[i] = id
+	}
+
+	var (
+		value map[string]interface{}
+		err   error
+	)
+	read := func(chan struct{}) bool {
+		value = make(map[string]interface{})
+		for i, k := range keys {
+			var es []streamEntry
+			if name == "xreadgroup" {
+				err = s.update(k, func(b *Bucket) error {
+					st, err := b.stream(k, false)
+					if err != nil {
+						return err
+					}
+					g := st.group(group)
+					if g == nil {
+						return GroupNotFoundError
+					}
+					es, err = st.readGroup(g, consumer, ids[i], int(count), noack, time.Now())
+					return err
+				})
+			} else {
+				err = s.view(k, func(b *Bucket) error {
+					st, err := b.stream(k, false)
+					if st != nil {
+						es = st.after(after[i], int(count))
+					}
+					return err
+				})
+			}
+			if err != nil {
+				return true
+			}
+			if len(es) > 0 {
+				value[k] = streamEntriesValue(es)
+			}
+		}
+		return len(value) > 0
+	}
+
+	if block < 0 {
+		read(nil)
+	} else if !s.block(keys, time.Duration(block)*time.Millisecond, c, read) {
+		value = nil
+	}
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if len(value) == 0 {
+		return s.response(map[string]interface{}{"value": nil})
+	}
+	return s.response(map[string]interface{}{"value": value})
+}
+
+// group returns the consumer group name of st, nil if st is nil or has no
+// such group.
+func (st *stream) group(name string) *streamGroup {
+	if st == nil {
+		return nil
+	}
+	return st.groups[name]
+}
+
+func (s *Store) execStreamGroup(name string, st *stream, cmd map[string]interface{}) map[string]interface{} {
+	if name == "xgroup" {
+		sub, errRes := s.stringArg(cmd, "sub")
+		if errRes != nil {
+			return errRes
+		}
+		group, errRes := s.stringArg(cmd, "group")
+		if errRes != nil {
+			return errRes
+		}
+		if st == nil {
+			return s.responseCmdExecuteError(ValueNotFoundError.Error())
+		}
+		switch sub {
+		case "create":
+			if st.groups[group] != nil {
+				return s.responseCmdExecuteError(GroupExistsError.Error())
+			}
+			ids, errRes := s.optionalStringArg(cmd, "id")
+			if errRes != nil {
+				return errRes
+			}
+			last := st.lastID
+			if ids != "" && ids != "$" {
+				id, err := parseStreamID(ids, 0)
+				if err != nil {
+					return s.responseCmdExecuteError(err.Error())
+				}
+				last = id
+			}
+			st.groups[group] = &streamGroup{
+				lastID:    last,
+				pending:   make(map[streamID]*streamPending),
+				consumers: make(map[string]time.Time),
+			}
+			return s.responseOK()
+		case "destroy":
+			_, ok := st.groups[group]
+			delete(st.groups, group)
+			return s.response(map[string]interface{}{"value": ok})
+		default:
+			return s.responseCmdNotFoundError()
+		}
+	}
+
+	group, errRes := s.stringArg(cmd, "group")
+	if errRes != nil {
+		return errRes
+	}
+	g := st.group(group)
+	if g == nil {
+		return s.responseCmdExecuteError(GroupNotFoundError.Error())
+	}
+	now := time.Now()
+
+	switch name {
+	case "xack":
+		ids, errRes := s.streamIDsArg(cmd)
+		if errRes != nil {
+			return errRes
+		}
+		n := 0
+		for _, id := range ids {
+			if _, ok := g.pending[id]; ok {
+				delete(g.pending, id)
+				n++
+			}
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "xpending":
+		if _, ok := cmd["start"]; !ok {
+			ids := g.pendingIDs("")
+			consumers := make(map[string]interface{})
+			for _, p := range g.pending {
+				n, _ := consumers[p.consumer].(int)
+				consumers[p.consumer] = n + 1
+			}
+			m := map[string]interface{}{"count": len(ids), "consumers": consumers}
+			if len(ids) > 0 {
+				m["min"] = ids[0].String()
+				m["max"] = ids[len(ids)-1].String()
+			}
+			return s.response(map[string]interface{}{"value": m})
+		}
+		return s.execXPendingRange(g, cmd, now)
+	case "xclaim":
+		consumer, errRes := s.stringArg(cmd, "consumer")
+		if errRes != nil {
+			return errRes
+		}
+		minIdle, errRes := s.optionalIntArg(cmd, "min_idle", 0)
+		if errRes != nil {
+			return errRes
+		}
+		ids, errRes := s.streamIDsArg(cmd)
+		if errRes != nil {
+			return errRes
+		}
+		g.consumers[consumer] = now
+		var es []streamEntry
+		for _, id := range ids {
+			p, ok := g.pending[id]
+			if !ok || now.Sub(p.delivered) < time.Duration(minIdle)*time.Millisecond {
+				continue
+			}
+			e, ok := st.entry(id)
+			if !ok {
+				// The entry was trimmed away, nobody can process it.
+				delete(g.pending, id)
+				continue
+			}
+			p.consumer = consumer
+			p.delivered = now
+			p.count++
+			es = append(es, e)
+		}
+		return s.response(map[string]interface{}{"value": streamEntriesValue(es)})
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// execXPendingRange lists the pending entries from "start" to "end",
+// optionally only those of "consumer".
+func (s *Store) execXPendingRange(g *streamGroup, cmd map[string]interface{}, now time.Time) map[string]interface{} {
+	bounds := make([]streamID, 0, 2)
+	for i, name := range []string{"start", "end"} {
+		v, errRes := s.optionalStringArg(cmd, name)
+		if errRes != nil {
+			return errRes
+		}
+		if v == "" {
+			v = []string{"-", "+"}[i]
+		}
+		id, err := parseStreamRange(v, i == 1)
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		bounds = append(bounds, id)
+	}
+	count, errRes := s.optionalIntArg(cmd, "count", 10)
+	if errRes != nil {
+		return errRes
+	}
+	consumer, errRes := s.optionalStringArg(cmd, "consumer")
+	if errRes != nil {
+		return errRes
+	}
+
+	r := make([]interface{}, 0)
+	for _, id := range g.pendingIDs(consumer) {
+		if id.less(bounds[0]) || bounds[1].less(id) {
+			continue
+		}
+		if count > 0 && int64(len(r)) == count {
+			break
+		}
+		p := g.pending[id]
+		r = append(r, map[string]interface{}{
+			"id":         id.String(),
+			"consumer":   p.consumer,
+			"idle":       int64(now.Sub(p.delivered) / time.Millisecond),
+			"deliveries": p.count,
+		})
+	}
+	return s.response(map[string]interface{}{"value": r})
+}
+
+func (s *Store) streamIDsArg(cmd map[string]interface{}) ([]streamID, map[string]interface{}) {
+	strs, errRes := s.stringsArg(cmd, "ids")
+	if errRes != nil {
+		return nil, errRes
+	}
+	ids := make([]streamID, 0, len(strs))
+	for _, str := range strs {
+		id, err := parseStreamID(str, 0)
+		if err != nil {
+			return nil, s.responseCmdExecuteError(err.Error())
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
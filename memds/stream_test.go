@@ -0,0 +1,254 @@
+package memds
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func streamIDs(t *testing.T, res map[string]interface{}) []string {
+	es, ok := res["value"].([]interface{})
+	if !ok {
+		t.Fatalf("got: %v, want entries", res)
+	}
+	ids := make([]string, 0, len(es))
+	for _, e := range es {
+		ids = append(ids, e.(map[string]interface{})["id"].(string))
+	}
+	return ids
+}
+
+func TestStream(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	fields := map[string]interface{}{"event": "login"}
+
+	for _, id := range []string{"1-1", "1-2", "2-0", "5"} {
+		res := exec(map[string]interface{}{"cmd": "xadd", "key": "events", "id": id, "fields": fields})
+		if res["status"] != true {
+			t.Fatalf("got: %v", res)
+		}
+	}
+	res := exec(map[string]interface{}{"cmd": "xadd", "key": "events", "id": "2-0", "fields": fields})
+	if res["msg"] != StreamIDTooSmallError.Error() {
+		t.Errorf("got: %v, want: %v", res, StreamIDTooSmallError)
+	}
+	res = exec(map[string]interface{}{"cmd": "xadd", "key": "events", "fields": fields})
+	auto, _ := res["value"].(string)
+	if id, err := parseStreamID(auto, 0); err != nil || !(streamID{ms: 5}).less(id) {
+		t.Errorf("got: %v, want an id above 5-0", auto)
+	}
+
+	testCase := []struct {
+		Cmd map[string]interface{}
+		IDs []string
+	}{
+		{
+			Cmd: map[string]interface{}{"cmd": "xrange", "key": "events", "start": "1", "end": "2"},
+			IDs: []string{"1-1", "1-2", "2-0"},
+		},
+		{
+			Cmd: map[string]interface{}{"cmd": "xrange", "key": "events", "start": "1-2", "count": 2},
+			IDs: []string{"1-2", "2-0"},
+		},
+		{
+			Cmd: map[string]interface{}{"cmd": "xrevrange", "key": "events", "end": "5", "count": 2},
+			IDs: []string{"5-0", "2-0"},
+		},
+		{
+			Cmd: map[string]interface{}{"cmd": "xrange", "key": "missing"},
+			IDs: []string{},
+		},
+	}
+	for _, tc := range testCase {
+		if ids := streamIDs(t, exec(tc.Cmd)); !reflect.DeepEqual(ids, tc.IDs) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, ids, tc.IDs)
+		}
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xtrim", "key": "events", "maxlen": 3})
+	if res["value"] != 2 {
+		t.Errorf("got: %v, want: 2", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "xlen", "key": "events"})
+	if res["value"] != 3 {
+		t.Errorf("got: %v, want: 3", res)
+	}
+
+	s.Set("plain", []byte("value"))
+	res = exec(map[string]interface{}{"cmd": "xadd", "key": "plain", "fields": fields})
+	if res["msg"] != WrongTypeError.Error() {
+		t.Errorf("got: %v, want: %v", res, WrongTypeError)
+	}
+	if _, err := s.Get("events"); err != WrongTypeError {
+		t.Errorf("got: %v, want: %v", err, WrongTypeError)
+	}
+
+	for _, cmd := range []map[string]interface{}{
+		{"cmd": "xadd", "key": "bad", "id": "0-0", "fields": fields},
+		{"cmd": "xadd", "key": "bad", "id": "x", "fields": fields},
+		{"cmd": "xadd", "key": "bad", "fields": map[string]interface{}{}},
+		{"cmd": "xgroup", "key": "bad", "sub": "create", "group": "g", "id": "x", "mkstream": true},
+	} {
+		if res := exec(cmd); res["status"] != false {
+			t.Errorf("%v got: %v, want: error", cmd, res)
+		}
+		if s.exists("bad") {
+			t.Errorf("%v got: exists, want: no key after a failed command", cmd)
+		}
+	}
+}
+
+func TestStreamRead(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	fields := map[string]interface{}{"n": 1}
+	exec(map[string]interface{}{"cmd": "xadd", "key": "a", "id": "1", "fields": fields})
+	exec(map[string]interface{}{"cmd": "xadd", "key": "a", "id": "2", "fields": fields})
+
+	res := exec(map[string]interface{}{"cmd": "xread", "keys": []interface{}{"a", "b"}, "ids": []interface{}{"1", "0"}})
+	v, _ := res["value"].(map[string]interface{})
+	if len(v) != 1 || len(v["a"].([]interface{})) != 1 {
+		t.Errorf("got: %v", res)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xread", "keys": "a", "ids": "$", "block": 20})
+	if res["status"] != true || res["value"] != nil {
+		t.Errorf("got: %v, want: nil value after timeout", res)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		exec(map[string]interface{}{"cmd": "xadd", "key": "a", "id": "3", "fields": fields})
+	}()
+	res = exec(map[string]interface{}{"cmd": "xread", "keys": "a", "ids": "$", "block": 0})
+	v, _ = res["value"].(map[string]interface{})
+	if ids := streamIDs(t, map[string]interface{}{"value": v["a"]}); !reflect.DeepEqual(ids, []string{"3-0"}) {
+		t.Errorf("got: %v, want: [3-0]", ids)
+	}
+
+	done := make(chan map[string]interface{})
+	go func() {
+		done <- exec(map[string]interface{}{"cmd": "xread", "keys": "a", "ids": "$", "block": 0})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.StopBlocking()
+	select {
+	case res := <-done:
+		if res["value"] != nil {
+			t.Errorf("got: %v, want: nil value", res)
+		}
+	case <-time.After(time.Second):
+		t.Error("blocked read not stopped")
+	}
+}
+
+func TestStreamGroup(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+	fields := map[string]interface{}{"n": 1}
+
+	res := exec(map[string]interface{}{"cmd": "xgroup", "sub": "create", "key": "jobs", "group": "g"})
+	if res["status"] != false {
+		t.Errorf("got: %v, want error without mkstream", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "xgroup", "sub": "create", "key": "jobs", "group": "g", "id": "0", "mkstream": true})
+	if res["status"] != true {
+		t.Fatalf("got: %v", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "xgroup", "sub": "create", "key": "jobs", "group": "g"})
+	if res["msg"] != GroupExistsError.Error() {
+		t.Errorf("got: %v, want: %v", res, GroupExistsError)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		exec(map[string]interface{}{"cmd": "xadd", "key": "jobs", "id": id, "fields": fields})
+	}
+
+	read := func(consumer, id string, count int) []string {
+		res := exec(map[string]interface{}{
+			"cmd": "xreadgroup", "group": "g", "consumer": consumer,
+			"keys": "jobs", "ids": id, "count": count,
+		})
+		v, _ := res["value"].(map[string]interface{})
+		if v == nil {
+			return []string{}
+		}
+		return streamIDs(t, map[string]interface{}{"value": v["jobs"]})
+	}
+	if ids := read("alice", ">", 2); !reflect.DeepEqual(ids, []string{"1-0", "2-0"}) {
+		t.Errorf("got: %v", ids)
+	}
+	if ids := read("bob", ">", 0); !reflect.DeepEqual(ids, []string{"3-0"}) {
+		t.Errorf("got: %v", ids)
+	}
+	if ids := read("bob", ">", 0); !reflect.DeepEqual(ids, []string{}) {
+		t.Errorf("got: %v", ids)
+	}
+	if ids := read("alice", "0", 0); !reflect.DeepEqual(ids, []string{"1-0", "2-0"}) {
+		t.Errorf("got: %v", ids)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xack", "key": "jobs", "group": "g", "ids": []interface{}{"1-0", "9-0"}})
+	if res["value"] != 1 {
+		t.Errorf("got: %v, want: 1", res)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xpending", "key": "jobs", "group": "g"})
+	summary, _ := res["value"].(map[string]interface{})
+	want := map[string]interface{}{
+		"count":     2,
+		"min":       "2-0",
+		"max":       "3-0",
+		"consumers": map[string]interface{}{"alice": 1, "bob": 1},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("got: %v, want: %v", summary, want)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xclaim", "key": "jobs", "group": "g", "consumer": "bob", "min_idle": 60000, "ids": "2-0"})
+	if ids := streamIDs(t, res); len(ids) != 0 {
+		t.Errorf("got: %v, want nothing claimed before min_idle", ids)
+	}
+	res = exec(map[string]interface{}{"cmd": "xclaim", "key": "jobs", "group": "g", "consumer": "bob", "ids": "2-0"})
+	if ids := streamIDs(t, res); !reflect.DeepEqual(ids, []string{"2-0"}) {
+		t.Errorf("got: %v", ids)
+	}
+
+	res = exec(map[string]interface{}{"cmd": "xpending", "key": "jobs", "group": "g", "start": "-", "consumer": "bob"})
+	pending, _ := res["value"].([]interface{})
+	if len(pending) != 2 {
+		t.Fatalf("got: %v", res)
+	}
+	p := pending[0].(map[string]interface{})
+	if p["id"] != "2-0" || p["deliveries"] != int64(2) {
+		t.Errorf("got: %v", p)
+	}
+}
+
+func TestStreamAddSeqOverflow(t *testing.T) {
+	st := newStream().(*stream)
+	now := time.Unix(0, 0)
+
+	id, err := st.add(&streamID{ms: 5, seq: math.MaxUint64}, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err = st.add(nil, nil, now); err != nil || id != (streamID{ms: 6}) {
+		t.Errorf("got: %v, %v, want: 6-0", id, err)
+	}
+
+	st.add(&maxStreamID, nil, now)
+	if id, err = st.add(nil, nil, now); err != StreamIDTooSmallError {
+		t.Errorf("got: %v, %v, want: %v", id, err, StreamIDTooSmallError)
+	}
+	if n := len(st.entries); n != 3 {
+		t.Errorf("got: %v entries, want: 3", n)
+	}
+}
@@ -0,0 +1,320 @@
+package memds
+
+import (
+	"math"
+	"sort"
+)
+
+type zsetEntry struct {
+	score  float64
+	member string
+}
+
+func (e zsetEntry) less(o zsetEntry) bool {
+	if e.score != o.score {
+		return e.score < o.score
+	}
+	return e.member < o.member
+}
+
+// zset is a set of members ordered by score.
+type zset struct {
+	scores map[string]float64
+	// entries holds the members ordered by score, then member.
+	entries []zsetEntry
+}
+
+func newZset() interface{} {
+	z := zset{scores: make(map[string]float64)}
+	return &z
+}
+
+// zset returns the sorted set at k, creating it if create is set. It returns
+// nil if k doesn't exist. The caller must hold b.mu, for writing if create is
+// set.
+func (b *Bucket) zset(k string, create bool) (*zset, error) {
+	var f func() interface{}
+	if create {
+		f = newZset
+	}
+	o, err := b.typed(k, f)
+	if err != nil || o == nil {
+		return nil, err
+	}
+	z, ok := o.(*zset)
+	if !ok {
+		return nil, WrongTypeError
+	}
+	return z, nil
+}
+
+func (z *zset) search(e zsetEntry) int {
+	return sort.Search(len(z.entries), func(i int) bool {
+		return !z.entries[i].less(e)
+	})
+}
+
+// add sets the score of member and reports whether it is a new member.
+func (z *zset) add(member string, score float64) bool {
+	isNew := !z.remove(member)
+	e := zsetEntry{score: score, member: member}
+	i := z.search(e)
+	z.entries = append(z.entries, zsetEntry{})
+	copy(z.entries[i+1:], z.entries[i:])
+	z.entries[i] = e
+	z.scores[member] = score
+	return isNew
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	i := z.search(zsetEntry{score: score, member: member})
+	z.entries = append(z.entries[:i], z.entries[i+1:]...)
+	delete(z.scores, member)
+	return true
+}
+
+// size counts each member once with its score in scores and entries.
+func (z *zset) size() int64 {
+	var n int64
+	for _, e := range z.entries {
+		n += int64(len(e.member)) + 16
+	}
+	return n
+}
+
+// popMin removes and returns up to count members with the lowest scores.
+func (z *zset) popMin(count int) []zsetEntry {
+	if count > len(z.entries) {
+		count = len(z.entries)
+	}
+	r := append([]zsetEntry(nil), z.entries[:count]...)
+	for _, e := range r {
+		z.remove(e.member)
+	}
+	return r
+}
+
+// byScore returns up to count members with scores from min to max, both
+// inclusive. count 0 means no limit.
+func (z *zset) byScore(min, max float64, count int) []zsetEntry {
+	i := z.search(zsetEntry{score: min})
+	var r []zsetEntry
+	for ; i < len(z.entries) && z.entries[i].score <= max; i++ {
+		if count > 0 && len(r) == count {
+			break
+		}
+		r = append(r, z.entries[i])
+	}
+	return r
+}
+
+// popZset pops up to count members with the lowest scores off the sorted set
+// at k, deleting the key once the set is empty. The caller must hold b.mu
+// for writing.
+func (b *Bucket) popZset(k string, count int) ([]zsetEntry, error) {
+	z, err := b.zset(k, false)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	r := z.popMin(count)
+	if len(z.entries) == 0 {
+		b.remove(k)
+	}
+	return r, nil
+}
+
+func zsetEntriesValue(es []zsetEntry) []interface{} {
+	r := make([]interface{}, 0, len(es))
+	for _, e := range es {
+		r = append(r, map[string]interface{}{"member": e.member, "score": e.score})
+	}
+	return r
+}
+
+func (s *Store) execZset(name string, cmd map[string]interface{}, c *client) map[string]interface{} {
+	if name == "bzpopmin" {
+		return s.execBzpopmin(cmd, c)
+	}
+
+	k, errRes := s.stringArg(cmd, "key")
+	if errRes != nil {
+		return errRes
+	}
+	var res map[string]interface{}
+	err := s.update(k, func(b *Bucket) error {
+		res = s.execZsetOn(name, b, k, cmd)
+		return nil
+	})
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if name == "zadd" && res["status"] == true {
+		s.waiters.notify(k)
+	}
+	return res
+}
+
+// execZsetOn runs a sorted set command on the key k of b. The caller must
+// hold b.mu for writing.
+func (s *Store) execZsetOn(name string, b *Bucket, k string, cmd map[string]interface{}) map[string]interface{} {
+	z, err := b.zset(k, name == "zadd")
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	if z == nil {
+		z = newZset().(*zset)
+	}
+
+	switch name {
+	case "zadd":
+		scores, errRes := s.scoresArg(cmd)
+		if errRes != nil {
+			if len(z.entries) == 0 {
+				b.remove(k)
+			}
+			return errRes
+		}
+		n := 0
+		for m, f := range scores {
+			if z.add(m, f) {
+				n++
+			}
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "zrem":
+		members, errRes := s.stringsArg(cmd, "members")
+		if errRes != nil {
+			return errRes
+		}
+		n := 0
+		for _, m := range members {
+			if z.remove(m) {
+				n++
+			}
+		}
+		if n > 0 && len(z.entries) == 0 {
+			b.remove(k)
+		}
+		return s.response(map[string]interface{}{"value": n})
+	case "zscore":
+		m, errRes := s.stringArg(cmd, "member")
+		if errRes != nil {
+			return errRes
+		}
+		score, ok := z.scores[m]
+		if !ok {
+			return s.response(map[string]interface{}{"value": nil})
+		}
+		return s.response(map[string]interface{}{"value": score})
+	case "zcard":
+		return s.response(map[string]interface{}{"value": len(z.entries)})
+	case "zrange":
+		start, errRes := s.optionalIntArg(cmd, "start", 0)
+		if errRes != nil {
+			return errRes
+		}
+		stop, errRes := s.optionalIntArg(cmd, "stop", -1)
+		if errRes != nil {
+			return errRes
+		}
+		i, j := rangeIndex(start, stop, len(z.entries))
+		return s.response(map[string]interface{}{"value": zsetEntriesValue(z.entries[i:j])})
+	case "zrangebyscore":
+		bounds := []float64{math.Inf(-1), math.Inf(1)}
+		for i, name := range []string{"min", "max"} {
+			if v, ok := cmd[name]; ok {
+				f, ok := floatValue(v)
+				if !ok {
+					return s.responseCmdFormatError("key '" + name + "' not type number")
+				}
+				bounds[i] = f
+			}
+		}
+		count, errRes := s.optionalIntArg(cmd, "count", 0)
+		if errRes != nil {
+			return errRes
+		}
+		es := z.byScore(bounds[0], bounds[1], int(count))
+		return s.response(map[string]interface{}{"value": zsetEntriesValue(es)})
+	case "zpopmin":
+		count, errRes := s.optionalIntArg(cmd, "count", 1)
+		if errRes != nil {
+			return errRes
+		}
+		if count < 1 {
+			return s.responseCmdFormatError("key 'count' not above 0")
+		}
+		es, err := b.popZset(k, int(count))
+		if err != nil {
+			return s.responseCmdExecuteError(err.Error())
+		}
+		return s.response(map[string]interface{}{"value": zsetEntriesValue(es)})
+	default:
+		return s.responseCmdNotFoundError()
+	}
+}
+
+// scoresArg returns cmd["members"] as a map of members to their scores.
+func (s *Store) scoresArg(cmd map[string]interface{}) (map[string]float64, map[string]interface{}) {
+	members, ok := cmd["members"].(map[string]interface{})
+	if !ok || len(members) == 0 {
+		return nil, s.responseCmdFormatError("key 'members' not type map")
+	}
+	scores := make(map[string]float64, len(members))
+	for m, v := range members {
+		f, ok := floatValue(v)
+		if !ok || math.IsNaN(f) {
+			return nil, s.responseCmdFormatError("key 'members' scores not type number")
+		}
+		scores[m] = f
+	}
+	return scores, nil
+}
+
+// execBzpopmin is zpopmin over the first of keys holding members, waiting
+// up to "timeout" seconds for one like blpop.
+func (s *Store) execBzpopmin(cmd map[string]interface{}, c *client) map[string]interface{} {
+	keys, errRes := s.stringsArg(cmd, "keys")
+	if errRes != nil {
+		return errRes
+	}
+	timeout, errRes := s.timeoutArg(cmd)
+	if errRes != nil {
+		return errRes
+	}
+
+	var (
+		value interface{}
+		err   error
+	)
+	pop := func(ch chan struct{}) bool {
+		for _, k := range keys {
+			if !s.waiters.turn(k, ch) {
+				continue
+			}
+			err = s.update(k, func(b *Bucket) error {
+				es, err := b.popZset(k, 1)
+				if len(es) > 0 {
+					value = map[string]interface{}{"key": k, "member": es[0].member, "score": es[0].score}
+				}
+				return err
+			})
+			if err != nil || value != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !pop(nil) {
+		s.block(keys, timeout, c, pop)
+	}
+	if err != nil {
+		return s.responseCmdExecuteError(err.Error())
+	}
+	return s.response(map[string]interface{}{"value": value})
+}
@@ -0,0 +1,124 @@
+package memds
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZset(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	members := map[string]interface{}{"a": int64(3), "b": 1.5, "c": uint64(2), "d": int64(2)}
+	res := exec(map[string]interface{}{"cmd": "zadd", "key": "z", "members": members})
+	if res["value"] != 4 {
+		t.Errorf("got: %v, want: 4", res)
+	}
+	res = exec(map[string]interface{}{"cmd": "zadd", "key": "z", "members": map[string]interface{}{"a": 0.5}})
+	if res["value"] != 0 {
+		t.Errorf("got: %v, want: 0", res)
+	}
+
+	entry := func(m string, score float64) interface{} {
+		return map[string]interface{}{"member": m, "score": score}
+	}
+	testCase := []struct {
+		Cmd   map[string]interface{}
+		Value interface{}
+	}{
+		{
+			Cmd:   map[string]interface{}{"cmd": "zrange", "key": "z"},
+			Value: []interface{}{entry("a", 0.5), entry("b", 1.5), entry("c", 2), entry("d", 2)},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zrange", "key": "z", "start": -2},
+			Value: []interface{}{entry("c", 2), entry("d", 2)},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zrangebyscore", "key": "z", "min": 1, "max": 2, "count": 2},
+			Value: []interface{}{entry("b", 1.5), entry("c", 2)},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zscore", "key": "z", "member": "b"},
+			Value: 1.5,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zscore", "key": "z", "member": "x"},
+			Value: nil,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zrem", "key": "z", "members": []interface{}{"b", "x"}},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zpopmin", "key": "z", "count": 2},
+			Value: []interface{}{entry("a", 0.5), entry("c", 2)},
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zcard", "key": "z"},
+			Value: 1,
+		},
+		{
+			Cmd:   map[string]interface{}{"cmd": "zrange", "key": "missing"},
+			Value: []interface{}{},
+		},
+	}
+	for _, tc := range testCase {
+		if res := exec(tc.Cmd); !reflect.DeepEqual(res["value"], tc.Value) {
+			t.Errorf("%v got: %v, want: %v", tc.Cmd, res["value"], tc.Value)
+		}
+	}
+
+	res = exec(map[string]interface{}{"cmd": "zadd", "key": "bad", "members": map[string]interface{}{"a": "x"}})
+	if res["code"] != ErrorCodeCommandFormatError {
+		t.Errorf("got: %v, want: format error", res)
+	}
+	if s.exists("bad") {
+		t.Error("got: exists, want: no key after a bad zadd")
+	}
+
+	for _, count := range []int64{0, -1} {
+		res = exec(map[string]interface{}{"cmd": "zpopmin", "key": "z", "count": count})
+		if res["code"] != ErrorCodeCommandFormatError {
+			t.Errorf("count %d got: %v, want: format error", count, res)
+		}
+	}
+}
+
+func TestBzpopmin(t *testing.T) {
+	s, _ := NewStore(&Config{BucketNum: 2})
+	exec := func(cmd map[string]interface{}) map[string]interface{} {
+		return s.execute(cmd, new(client))
+	}
+
+	res := exec(map[string]interface{}{"cmd": "bzpopmin", "keys": "z", "timeout": 0.02})
+	if res["status"] != true || res["value"] != nil {
+		t.Errorf("got: %v, want: nil value after timeout", res)
+	}
+
+	done := make(chan map[string]interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- exec(map[string]interface{}{"cmd": "bzpopmin", "keys": []interface{}{"y", "z"}})
+		}()
+		for !waiting(s, "z", i+1) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	exec(map[string]interface{}{"cmd": "zadd", "key": "z", "members": map[string]interface{}{"a": 2, "b": 1}})
+	var got []interface{}
+	for i := 0; i < 2; i++ {
+		select {
+		case res := <-done:
+			got = append(got, res["value"].(map[string]interface{})["member"])
+		case <-time.After(time.Second):
+			t.Fatal("blocked pop not served")
+		}
+	}
+	if !reflect.DeepEqual(got, []interface{}{"b", "a"}) {
+		t.Errorf("got: %v, want: [b a]", got)
+	}
+}